@@ -0,0 +1,339 @@
+package pulsar
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// MaxArchiveRecords is the maximum number of archive values fitting a single response frame.
+const MaxArchiveRecords = (255 - minFrameLen - 10) / 4
+
+// NoData is a value device returns for archive records it has no data for.
+var NoData = math.Float32frombits(0xFFFFFFF0)
+
+// IsNoData reports whether archive value v is a missing record.
+func IsNoData(v float32) bool {
+	return math.Float32bits(v) == math.Float32bits(NoData)
+}
+
+// Gap is a period of archive records device has no archive for. Both bounds are included.
+type Gap struct {
+	// First missing record time.
+	From time.Time
+	// Last missing record time.
+	To time.Time
+}
+
+// ArchiveOptions configures archive retrieval.
+type ArchiveOptions struct {
+	// Maximum number of records requested at once. Defaults to MaxArchiveRecords.
+	// It is halved each time device responds with TooLongPeriod.
+	ChunkSize int
+	// Progress is called after each request with the number of fetched and total records.
+	Progress func(done, total int)
+}
+
+// Archive retrieves typ archive records of the channel with times within [from, to] range.
+// The range is split into requests device accepts, missing records are NoData values reported in ChannelLog.Gaps.
+// If a request fails the log of records fetched so far is returned along with the error.
+func (c *Client) Archive(typ ArchType, ch uint, from, to time.Time, opts ArchiveOptions) (*ChannelLog, error) {
+	return c.ArchiveContext(context.Background(), typ, ch, from, to, opts)
+}
+
+// ArchiveContext is like Archive but aborts the request when ctx is done.
+func (c *Client) ArchiveContext(ctx context.Context, typ ArchType, ch uint, from, to time.Time, opts ArchiveOptions) (*ChannelLog, error) {
+	if typ < Hourly || typ > Monthly {
+		return nil, fmt.Errorf("unknown archive type %d", typ)
+	}
+	loc, err := c.zone(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if loc != nil {
+		from, to = from.In(loc), to.In(loc)
+	}
+	// periods are calculated on device wall clock.
+	start, end := typ.Truncate(wallClock(from)), typ.Truncate(wallClock(to))
+	if end.Before(start) {
+		return nil, fmt.Errorf("invalid period: %v is after %v", from, to)
+	}
+	chunk := opts.ChunkSize
+	if chunk <= 0 || chunk > MaxArchiveRecords {
+		chunk = MaxArchiveRecords
+	}
+
+	total := periods(typ, start, end) + 1
+	l := &ChannelLog{Id: ch, Type: typ, Start: start, Values: make([]float32, total)}
+	for i := range l.Values {
+		l.Values[i] = NoData
+	}
+	done, err := l.fetch(ctx, c, chunk, opts.Progress)
+	// the log is cut to fetched records before it is moved to loc, where records don't follow wall clock.
+	l.Values = l.Values[:done]
+	if loc != nil {
+		l.inLocation(loc)
+	}
+	return l, err
+}
+
+// fetch requests records of the log in chunks and returns the number of fetched ones.
+// Log times are wall clock times in UTC.
+func (l *ChannelLog) fetch(ctx context.Context, c *Client, chunk int, progress func(done, total int)) (int, error) {
+	typ, ch, start, total := l.Type, l.Id, l.Start, len(l.Values)
+	var done int
+	for done < total {
+		n := total - done
+		if n > chunk {
+			n = chunk
+		}
+		first, last := typ.Advance(start, done), typ.Advance(start, done+n-1)
+		part, err := c.valuesLog(ctx, typ, ch, sysTime(first), sysTime(last))
+		var pe *ProtocolError
+		switch {
+		case err == nil:
+			if k := l.merge(part, done, n); k > 0 {
+				n = k
+			} else {
+				l.addGap(first, last)
+			}
+		case errors.As(err, &pe) && pe.Code() == TooLongPeriod && n > 1:
+			chunk = n / 2
+			continue
+		case errors.As(err, &pe) && pe.Code() == MissingArchive:
+			l.addGap(first, last)
+		default:
+			return done, err
+		}
+		done += n
+		if progress != nil {
+			progress(done, total)
+		}
+	}
+	return done, nil
+}
+
+// merge copies values of part into the n records starting from done.
+// Returns the number of records covered by part.
+func (l *ChannelLog) merge(part *ChannelLog, done, n int) int {
+	off := periods(l.Type, l.Start, part.Start)
+	var k int
+	for i, v := range part.Values {
+		idx := off + i
+		if idx < done || idx >= done+n {
+			continue
+		}
+		l.Values[idx] = v
+		k = idx - done + 1
+	}
+	return k
+}
+
+// addGap appends a gap merging it with the previous adjacent one.
+func (l *ChannelLog) addGap(from, to time.Time) {
+	if n := len(l.Gaps); n > 0 && l.Type.Advance(l.Gaps[n-1].To, 1).Equal(from) {
+		l.Gaps[n-1].To = to
+		return
+	}
+	l.Gaps = append(l.Gaps, Gap{From: from, To: to})
+}
+
+// HourlyLogs is like HourlyLog but retrieves archives of several channels.
+func (c *Client) HourlyLogs(from, to time.Time, chs ...uint) ([]*ChannelLog, error) {
+	return c.HourlyLogsContext(context.Background(), from, to, chs...)
+}
+
+// HourlyLogsContext is like HourlyLogs but aborts the request when ctx is done.
+func (c *Client) HourlyLogsContext(ctx context.Context, from, to time.Time, chs ...uint) ([]*ChannelLog, error) {
+	return c.channelLogs(ctx, Hourly, from, to, chs)
+}
+
+// DailyLogs is like DailyLog but retrieves archives of several channels.
+func (c *Client) DailyLogs(from, to time.Time, chs ...uint) ([]*ChannelLog, error) {
+	return c.DailyLogsContext(context.Background(), from, to, chs...)
+}
+
+// DailyLogsContext is like DailyLogs but aborts the request when ctx is done.
+func (c *Client) DailyLogsContext(ctx context.Context, from, to time.Time, chs ...uint) ([]*ChannelLog, error) {
+	return c.channelLogs(ctx, Daily, from, to, chs)
+}
+
+// MonthlyLogs is like MonthlyLog but retrieves archives of several channels.
+func (c *Client) MonthlyLogs(from, to time.Time, chs ...uint) ([]*ChannelLog, error) {
+	return c.MonthlyLogsContext(context.Background(), from, to, chs...)
+}
+
+// MonthlyLogsContext is like MonthlyLogs but aborts the request when ctx is done.
+func (c *Client) MonthlyLogsContext(ctx context.Context, from, to time.Time, chs ...uint) ([]*ChannelLog, error) {
+	return c.channelLogs(ctx, Monthly, from, to, chs)
+}
+
+// channelLogs requests archives of channels one by one or all at once if WithMultiChannelArchive is set.
+// If device rejects the multi-channel mask with InvalidBitMask channels are requested one by one,
+// further requests of the client skip the attempt.
+// Multi-channel response is expected to contain values of channels in ascending order one after another.
+func (c *Client) channelLogs(ctx context.Context, arch ArchType, from, to time.Time, chs []uint) ([]*ChannelLog, error) {
+	if err := validateChannels(chs...); err != nil {
+		return nil, err
+	}
+	chs = append([]uint(nil), chs...)
+	sort.Slice(chs, func(i, j int) bool { return chs[i] < chs[j] })
+	loc, err := c.zone(ctx)
+	if err != nil {
+		return nil, err
+	}
+	start, end := logPeriod(arch, from, to, loc)
+	rv, err := c.fetchLogs(ctx, arch, chs, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if loc != nil {
+		for _, l := range rv {
+			l.inLocation(loc)
+		}
+	}
+	return rv, nil
+}
+
+// fetchLogs requests archives of chs channels sorted in ascending order.
+func (c *Client) fetchLogs(ctx context.Context, arch ArchType, chs []uint, start, end sysTime) ([]*ChannelLog, error) {
+	if len(chs) > 1 && c.multiArchive && atomic.LoadInt32(&c.singleArchive) == 0 {
+		rv, err := c.multiChannelLog(ctx, arch, chs, start, end)
+		var pe *ProtocolError
+		if !errors.As(err, &pe) || pe.Code() != InvalidBitMask {
+			return rv, err
+		}
+		atomic.StoreInt32(&c.singleArchive, 1)
+	}
+
+	rv := make([]*ChannelLog, 0, len(chs))
+	for _, ch := range chs {
+		l, err := c.valuesLog(ctx, arch, ch, start, end)
+		if err != nil {
+			return nil, err
+		}
+		l.Id, l.Type = ch, arch
+		rv = append(rv, l)
+	}
+	return rv, nil
+}
+
+// multiChannelLog requests archives of chs channels sorted in ascending order in a single request.
+func (c *Client) multiChannelLog(ctx context.Context, arch ArchType, chs []uint, from, to sysTime) ([]*ChannelLog, error) {
+	tmStart, _ := from.MarshalBinary()
+	tmEnd, _ := to.MarshalBinary()
+	data, err := c.command(ctx, fnReadArchive, func() []byte {
+		var b bytes.Buffer
+		_ = binary.Write(&b, binary.LittleEndian, makeMask(chs...))
+		_ = binary.Write(&b, binary.LittleEndian, uint16(arch))
+		_, _ = b.Write(tmStart)
+		_, _ = b.Write(tmEnd)
+		return b.Bytes()
+	})
+	if err != nil {
+		return nil, err
+	}
+	var all ChannelLog
+	if err := all.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if len(all.Values)%len(chs) != 0 {
+		return nil, ErrInvalidFrame
+	}
+	n := len(all.Values) / len(chs)
+	rv := make([]*ChannelLog, 0, len(chs))
+	for i, ch := range chs {
+		rv = append(rv, &ChannelLog{Id: ch, Type: arch, Start: all.Start, Values: all.Values[i*n : (i+1)*n : (i+1)*n]})
+	}
+	return rv, nil
+}
+
+// Record is a single archive record.
+type Record struct {
+	// Start of the record period.
+	Time time.Time
+	// Counter value, NoData if record is missing.
+	Value float32
+}
+
+// Delta is a counter change between two consecutive records.
+type Delta struct {
+	// Time of the first record.
+	From time.Time
+	// Time of the second record.
+	To time.Time
+	// Consumption, i.e. the difference of the records values.
+	Value float64
+}
+
+// Time returns the time of the i-th record.
+// Hourly records are an hour apart, daily and monthly ones are at midnight of the Start location,
+// so records around DST transitions and months of different lengths are handled correctly.
+func (l *ChannelLog) Time(i int) time.Time {
+	return l.Type.Advance(l.Start, i)
+}
+
+// Records returns values along with their times.
+func (l *ChannelLog) Records() []Record {
+	rv := make([]Record, len(l.Values))
+	for i, v := range l.Values {
+		rv[i] = Record{Time: l.Time(i), Value: v}
+	}
+	return rv
+}
+
+// Deltas returns consumption between consecutive records.
+// Pairs with a missing record are skipped.
+func (l *ChannelLog) Deltas() []Delta {
+	var rv []Delta
+	for i := 1; i < len(l.Values); i++ {
+		prev, cur := l.Values[i-1], l.Values[i]
+		if IsNoData(prev) || IsNoData(cur) {
+			continue
+		}
+		rv = append(rv, Delta{From: l.Time(i - 1), To: l.Time(i), Value: float64(cur) - float64(prev)})
+	}
+	return rv
+}
+
+// Truncate returns the start of the archive period containing tm in tm location.
+func (t ArchType) Truncate(tm time.Time) time.Time {
+	switch t {
+	case Daily:
+		return time.Date(tm.Year(), tm.Month(), tm.Day(), 0, 0, 0, 0, tm.Location())
+	case Monthly:
+		return time.Date(tm.Year(), tm.Month(), 1, 0, 0, 0, 0, tm.Location())
+	default:
+		return time.Date(tm.Year(), tm.Month(), tm.Day(), tm.Hour(), 0, 0, 0, tm.Location())
+	}
+}
+
+// Advance returns tm moved by n archive periods.
+func (t ArchType) Advance(tm time.Time, n int) time.Time {
+	switch t {
+	case Daily:
+		return tm.AddDate(0, 0, n)
+	case Monthly:
+		return tm.AddDate(0, n, 0)
+	default:
+		return tm.Add(time.Duration(n) * time.Hour)
+	}
+}
+
+// periods returns the number of archive periods between from and to. Both are wall clock times in UTC.
+func periods(typ ArchType, from, to time.Time) int {
+	switch typ {
+	case Daily:
+		return int(to.Sub(from).Hours()) / 24
+	case Monthly:
+		return (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month())
+	default:
+		return int(to.Sub(from).Hours())
+	}
+}
@@ -0,0 +1,214 @@
+package pulsar_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+	"github.com/srgsf/tvh-pulsar/simulator"
+)
+
+func TestArchiveChunks(t *testing.T) {
+	d, cl := startDevice(t, 1)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	values := make([]float32, 24*31)
+	for i := range values {
+		values[i] = float32(i)
+	}
+	d.SetArchive(pulsar.Hourly, 1, start, values)
+	d.SetMaxPeriod(pulsar.Hourly, 30)
+
+	var calls, last int
+	l, err := cl.Archive(pulsar.Hourly, 1, start, start.AddDate(0, 1, 0).Add(-time.Hour), pulsar.ArchiveOptions{
+		Progress: func(done, total int) {
+			calls++
+			if total != len(values) || done <= last {
+				t.Errorf("unexpected progress %d/%d", done, total)
+			}
+			last = done
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != len(values) || calls == 0 {
+		t.Errorf("progress isn't reported: %d calls, last %d", calls, last)
+	}
+	if l.Id != 1 || l.Type != pulsar.Hourly || !l.Start.Equal(start) || len(l.Gaps) != 0 {
+		t.Errorf("unexpected log %v %v %v %v", l.Id, l.Type, l.Start, l.Gaps)
+	}
+	if len(l.Values) != len(values) {
+		t.Fatalf("expected %d values, got %d", len(values), len(l.Values))
+	}
+	for i, v := range l.Values {
+		if v != values[i] {
+			t.Fatalf("value %d: expected %v, got %v", i, values[i], v)
+		}
+	}
+}
+
+func TestArchiveGaps(t *testing.T) {
+	d, cl := startDevice(t, 1)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.SetArchive(pulsar.Daily, 2, start, []float32{1, 2})
+	d.SetArchive(pulsar.Daily, 2, start.AddDate(0, 0, 100), []float32{3})
+
+	l, err := cl.Archive(pulsar.Daily, 2, start, start.AddDate(0, 0, 100), pulsar.ArchiveOptions{ChunkSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Values) != 101 || l.Values[0] != 1 || l.Values[1] != 2 || l.Values[100] != 3 {
+		t.Fatalf("unexpected values %v", l.Values)
+	}
+	if !pulsar.IsNoData(l.Values[2]) || !pulsar.IsNoData(l.Values[99]) {
+		t.Error("missing records aren't marked")
+	}
+	if len(l.Gaps) != 1 || !l.Gaps[0].From.Equal(start.AddDate(0, 0, 10)) || !l.Gaps[0].To.Equal(start.AddDate(0, 0, 99)) {
+		t.Errorf("unexpected gaps %v", l.Gaps)
+	}
+}
+
+func TestArchiveCancel(t *testing.T) {
+	d, cl := startDevice(t, 1)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.SetArchive(pulsar.Hourly, 1, start, []float32{1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l, err := cl.ArchiveContext(ctx, pulsar.Hourly, 1, start, start.AddDate(0, 0, 10), pulsar.ArchiveOptions{
+		ChunkSize: 24,
+		Progress: func(done, total int) {
+			if done >= 48 {
+				cancel()
+			}
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+	if l == nil || len(l.Values) != 48 || l.Values[0] != 1 {
+		t.Error("fetched records aren't returned")
+	}
+	if n := d.Requests(simulator.ReadArchive); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		typ   pulsar.ArchType
+		start time.Time
+		exp   []time.Time
+	}{
+		{pulsar.Hourly, time.Date(2022, 3, 27, 1, 0, 0, 0, loc), []time.Time{
+			time.Date(2022, 3, 27, 1, 0, 0, 0, loc),
+			time.Date(2022, 3, 27, 3, 0, 0, 0, loc),
+			time.Date(2022, 3, 27, 4, 0, 0, 0, loc),
+		}},
+		{pulsar.Daily, time.Date(2022, 3, 26, 0, 0, 0, 0, loc), []time.Time{
+			time.Date(2022, 3, 26, 0, 0, 0, 0, loc),
+			time.Date(2022, 3, 27, 0, 0, 0, 0, loc),
+			time.Date(2022, 3, 28, 0, 0, 0, 0, loc),
+		}},
+		{pulsar.Monthly, time.Date(2022, 1, 1, 0, 0, 0, 0, loc), []time.Time{
+			time.Date(2022, 1, 1, 0, 0, 0, 0, loc),
+			time.Date(2022, 2, 1, 0, 0, 0, 0, loc),
+			time.Date(2022, 3, 1, 0, 0, 0, 0, loc),
+		}},
+	}
+	for _, test := range tests {
+		l := pulsar.ChannelLog{Type: test.typ, Start: test.start, Values: []float32{1, 2, 3}}
+		for i, r := range l.Records() {
+			if !r.Time.Equal(test.exp[i]) || r.Value != l.Values[i] {
+				t.Errorf("type %d record %d: expected %v, got %v", test.typ, i, test.exp[i], r.Time)
+			}
+		}
+	}
+}
+
+func TestDeltas(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := pulsar.ChannelLog{Type: pulsar.Daily, Start: start, Values: []float32{1, 1.5, pulsar.NoData, 4, 6}}
+	d := l.Deltas()
+	if len(d) != 2 {
+		t.Fatalf("unexpected deltas %v", d)
+	}
+	if d[0].Value != 0.5 || !d[0].From.Equal(start) || !d[0].To.Equal(start.AddDate(0, 0, 1)) {
+		t.Errorf("unexpected delta %v", d[0])
+	}
+	if d[1].Value != 2 || !d[1].From.Equal(start.AddDate(0, 0, 3)) {
+		t.Errorf("unexpected delta %v", d[1])
+	}
+}
+
+func TestLogsFallback(t *testing.T) {
+	for _, test := range []struct {
+		opts []pulsar.ClientOption
+		exp  []int
+	}{
+		{nil, []int{2, 2}},
+		{[]pulsar.ClientOption{pulsar.WithMultiChannelArchive()}, []int{3, 2}},
+	} {
+		testLogsFallback(t, test.exp, test.opts...)
+	}
+}
+
+func testLogsFallback(t *testing.T, requests []int, opts ...pulsar.ClientOption) {
+	d, cl := startDevice(t, 1, opts...)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.SetArchive(pulsar.Daily, 1, start, []float32{1, 2})
+	d.SetArchive(pulsar.Daily, 3, start, []float32{3, 4})
+
+	for i, exp := range requests {
+		before := d.Requests(simulator.ReadArchive)
+		logs, err := cl.DailyLogs(start, start.AddDate(0, 0, 1), 3, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := d.Requests(simulator.ReadArchive) - before; n != exp {
+			t.Errorf("call %d: expected %d requests, got %d", i, exp, n)
+		}
+		if len(logs) != 2 || logs[0].Id != 1 || logs[1].Id != 3 || logs[1].Type != pulsar.Daily ||
+			len(logs[1].Values) != 2 || logs[1].Values[1] != 4 {
+			t.Errorf("unexpected logs %v %v", logs[0], logs[1])
+		}
+	}
+}
+
+func TestArchTypePeriods(t *testing.T) {
+	tm := time.Date(2022, 3, 27, 1, 30, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		typ       pulsar.ArchType
+		start, at time.Time
+	}{
+		{pulsar.Hourly, time.Date(2022, 3, 27, 1, 0, 0, 0, time.UTC), time.Date(2022, 3, 27, 3, 0, 0, 0, time.UTC)},
+		{pulsar.Daily, time.Date(2022, 3, 27, 0, 0, 0, 0, time.UTC), time.Date(2022, 3, 29, 0, 0, 0, 0, time.UTC)},
+		{pulsar.Monthly, time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		start := tt.typ.Truncate(tm)
+		if !start.Equal(tt.start) {
+			t.Errorf("%v: unexpected period start %v", tt.typ, start)
+		}
+		if at := tt.typ.Advance(start, 2); !at.Equal(tt.at) {
+			t.Errorf("%v: unexpected advanced time %v", tt.typ, at)
+		}
+	}
+}
+
+func TestLogChannel(t *testing.T) {
+	d, cl := startDevice(t, 1)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.SetArchive(pulsar.Hourly, 9, start, []float32{1, 2})
+	l, err := cl.HourlyLog(9, start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Id != 9 || len(l.Values) != 2 || l.Values[1] != 2 {
+		t.Errorf("unexpected log %+v", l)
+	}
+}
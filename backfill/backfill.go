@@ -0,0 +1,161 @@
+// Package backfill fetches archive records missing since the last run, e.g. after a network outage.
+//
+// Backfiller remembers the time of the last stored record of every device channel archive in a Store
+// and requests only the records after it. Records are written and flushed before the checkpoint is saved,
+// so an interrupted backfill is resumed from the last saved checkpoint and
+// records written after it may be written once again.
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+	"github.com/srgsf/tvh-pulsar/export"
+)
+
+// DefaultStorePath is a checkpoint file used if Options.Store isn't set.
+const DefaultStorePath = "pulsar-backfill.json"
+
+// DefaultDepth is the number of records requested by archive type if Options.Depth doesn't set it.
+var DefaultDepth = map[pulsar.ArchType]int{
+	pulsar.Hourly:  62 * 24,
+	pulsar.Daily:   184,
+	pulsar.Monthly: 24,
+}
+
+// Options configures a Backfiller.
+type Options struct {
+	// Checkpoint store. Defaults to FileStore at DefaultStorePath.
+	Store Store
+	// Maximum number of the last complete records requested by archive type.
+	// Archives without a checkpoint are requested from the oldest of them,
+	// older checkpoints are ignored as device doesn't keep such records anymore.
+	Depth map[pulsar.ArchType]int
+	// Logger for backfill progress. Nothing is logged if nil.
+	Logger *log.Logger
+}
+
+// Backfiller writes archive records missing since the last stored ones.
+type Backfiller struct {
+	w    export.Writer
+	opts Options
+}
+
+// New creates a backfiller writing records to w.
+func New(w export.Writer, opts Options) (*Backfiller, error) {
+	if opts.Store == nil {
+		s, err := OpenFileStore(DefaultStorePath)
+		if err != nil {
+			return nil, err
+		}
+		opts.Store = s
+	}
+	for typ, n := range opts.Depth {
+		if typ < pulsar.Hourly || typ > pulsar.Monthly {
+			return nil, fmt.Errorf("unknown archive type %d", typ)
+		}
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid %s depth %d", typ, n)
+		}
+	}
+	return &Backfiller{w: w, opts: opts}, nil
+}
+
+// Run writes typ archive records of chs channels missing since their checkpoints up to the last complete period.
+// Records are requested with pulsar.Client.ArchiveContext, if it fails records fetched so far are stored.
+// Trailing missing records aren't stored, so they are requested again on the next run.
+func (b *Backfiller) Run(ctx context.Context, c *pulsar.Client, typ pulsar.ArchType, chs ...uint) error {
+	if typ < pulsar.Hourly || typ > pulsar.Monthly {
+		return fmt.Errorf("unknown archive type %d", typ)
+	}
+	for _, ch := range chs {
+		k := Key{Address: c.Address(), Channel: ch, Type: typ}
+		if err := b.channel(ctx, c, k); err != nil {
+			return fmt.Errorf("%v: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// Missing returns the range of records of the key to request. Range is empty if from is after to.
+func (b *Backfiller) Missing(c *pulsar.Client, k Key) (from, to time.Time, err error) {
+	to = lastComplete(c, k.Type)
+	depth, ok := b.opts.Depth[k.Type]
+	if !ok {
+		depth = DefaultDepth[k.Type]
+	}
+	from = k.Type.Advance(to, 1-depth)
+	t, ok, err := b.opts.Store.Load(k)
+	if err != nil {
+		return from, to, err
+	}
+	if ok {
+		if t = k.Type.Advance(t.In(to.Location()), 1); t.After(from) {
+			from = t
+		}
+	}
+	return from, to, nil
+}
+
+// channel writes missing records of the channel archive.
+func (b *Backfiller) channel(ctx context.Context, c *pulsar.Client, k Key) error {
+	from, to, err := b.Missing(c, k)
+	if err != nil {
+		return err
+	}
+	if from.After(to) {
+		return nil
+	}
+	b.logf("%v: requesting records from %v to %v", k, from, to)
+	progress := func(done, total int) {
+		b.logf("%v: fetched %d of %d records", k, done, total)
+	}
+	l, err := c.ArchiveContext(ctx, k.Type, k.Channel, from, to, pulsar.ArchiveOptions{Progress: progress})
+	if l != nil {
+		if err := b.store(k, l); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// store writes records of the log up to the last existing one and saves its time as the checkpoint.
+func (b *Backfiller) store(k Key, l *pulsar.ChannelLog) error {
+	i := len(l.Values) - 1
+	for i >= 0 && pulsar.IsNoData(l.Values[i]) {
+		i--
+	}
+	if i < 0 {
+		return nil
+	}
+	l.Values = l.Values[:i+1]
+	if err := b.w.WriteLog(k.Address, l); err != nil {
+		return err
+	}
+	if err := b.w.Flush(); err != nil {
+		return err
+	}
+	b.logf("%v: stored records up to %v", k, l.Time(i))
+	return b.opts.Store.Save(k, l.Time(i))
+}
+
+// lastComplete returns the start of the last complete archive period on device clock.
+// Without client location device clock is wall clock in UTC.
+func lastComplete(c *pulsar.Client, typ pulsar.ArchType) time.Time {
+	now := time.Now()
+	if loc := c.Location(); loc != nil {
+		now = now.In(loc)
+	} else {
+		now = time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), 0, time.UTC)
+	}
+	return typ.Advance(typ.Truncate(now), -1)
+}
+
+func (b *Backfiller) logf(format string, v ...interface{}) {
+	if b.opts.Logger != nil {
+		b.opts.Logger.Printf(format, v...)
+	}
+}
@@ -0,0 +1,120 @@
+package backfill
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+	"github.com/srgsf/tvh-pulsar/export"
+	"github.com/srgsf/tvh-pulsar/simulator"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := Key{Address: 0x00112233, Channel: 2, Type: pulsar.Daily}
+	if k.String() != "00112233/2/daily" {
+		t.Errorf("unexpected key %s", k)
+	}
+	if _, ok, err := s.Load(k); ok || err != nil {
+		t.Errorf("unexpected checkpoint of empty store %v %v", ok, err)
+	}
+	tm := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	if err := s.Save(k, tm); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = OpenFileStore(path); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := s.Load(k); !ok || err != nil || !v.Equal(tm) {
+		t.Errorf("unexpected checkpoint %v %v %v", v, ok, err)
+	}
+	matches, _ := filepath.Glob(path + ".*")
+	if len(matches) != 0 {
+		t.Errorf("temporary files are left %v", matches)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	s := simulator.New()
+	d := s.AddDevice(1)
+	d.SetLocation(time.Local)
+	d.SetDayLightSaving(true)
+	d.SetMaxPeriod(pulsar.Daily, 20)
+	now := time.Now()
+	last := time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, time.Local)
+	start := last.AddDate(0, 0, -99)
+	values := make([]float32, 100)
+	for i := range values {
+		values[i] = float32(i)
+	}
+	d.SetArchive(pulsar.Daily, 1, start, values)
+	d.SetArchive(pulsar.Daily, 10, start, values[:90])
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Skipf("tcp is not available: %v", err)
+	}
+	dl := pulsar.Dialer{RWTimeOut: time.Second}
+	conn, err := dl.DialTCP(s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+		_ = s.Close()
+	}()
+	c, _ := pulsar.NewClient("00000001", conn)
+
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	run := func() []string {
+		t.Helper()
+		store, err := OpenFileStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		b, err := New(export.NewCSVWriter(&buf), Options{Store: store, Depth: map[pulsar.ArchType]int{pulsar.Daily: 80}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Run(context.Background(), c, pulsar.Daily, 1, 10); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSpace(buf.String()), "\n")[1:]
+	}
+
+	lines := run()
+	// the last 80 records of channel 1 and 70 existing ones of channel 10.
+	if len(lines) != 150 {
+		t.Fatalf("unexpected number of records %d", len(lines))
+	}
+	if !strings.HasPrefix(lines[0], "00000001,1,daily,") || !strings.HasSuffix(lines[0], ",20") {
+		t.Errorf("unexpected first record %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[149], "00000001,10,daily,") || !strings.HasSuffix(lines[149], ",89") {
+		t.Errorf("unexpected last record %q", lines[149])
+	}
+
+	// device archive is complete now, only missing records are requested.
+	d.SetArchive(pulsar.Daily, 10, start, values)
+	requests := d.Requests(simulator.ReadArchive)
+	lines = run()
+	if len(lines) != 10 {
+		t.Fatalf("unexpected number of records %d", len(lines))
+	}
+	if !strings.HasPrefix(lines[0], "00000001,10,daily,") || !strings.HasSuffix(lines[0], ",90") {
+		t.Errorf("unexpected first record %q", lines[0])
+	}
+	if n := d.Requests(simulator.ReadArchive) - requests; n != 1 {
+		t.Errorf("unexpected number of requests %d", n)
+	}
+	if lines = run(); len(lines) != 0 {
+		t.Errorf("unexpected records %v", lines)
+	}
+}
@@ -0,0 +1,114 @@
+package backfill
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+)
+
+// Key identifies an archive of a device channel.
+type Key struct {
+	// Device address.
+	Address uint32
+	// Channel number.
+	Channel uint
+	// Archive type.
+	Type pulsar.ArchType
+}
+
+// String returns key as address/channel/type, e.g. 00112233/1/hourly.
+func (k Key) String() string {
+	return fmt.Sprintf("%08x/%d/%s", k.Address, k.Channel, k.Type)
+}
+
+// Store persists checkpoints, i.e. times of the last stored archive records.
+type Store interface {
+	// Load returns the checkpoint of the key. Reports false if there is no checkpoint.
+	Load(k Key) (time.Time, bool, error)
+	// Save sets the checkpoint of the key.
+	Save(k Key, t time.Time) error
+}
+
+// FileStore is a Store keeping checkpoints in a JSON file.
+// The file is replaced atomically on every Save, so it stays consistent if the process crashes.
+// FileStore is safe for concurrent use.
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+	// checkpoints by key string.
+	m map[string]time.Time
+}
+
+// OpenFileStore opens a checkpoint file. Missing file is treated as empty one and is created on the first Save.
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, m: make(map[string]time.Time)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Load returns the checkpoint of the key.
+func (s *FileStore) Load(k Key) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.m[k.String()]
+	return t, ok, nil
+}
+
+// Save sets the checkpoint of the key and writes the file.
+func (s *FileStore) Save(k Key, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := k.String()
+	prev, ok := s.m[key]
+	s.m[key] = t
+	if err := s.write(); err != nil {
+		if ok {
+			s.m[key] = prev
+		} else {
+			delete(s.m, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// write replaces the file with a temporary one. Must be called with mu held.
+func (s *FileStore) write() error {
+	data, err := json.MarshalIndent(s.m, "", "\t")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
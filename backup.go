@@ -0,0 +1,245 @@
+package pulsar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// BackupVersion is a version of backup document format written by WriteBackup.
+const BackupVersion = 1
+
+// Backup is a portable device configuration document used to move settings to a replacement unit.
+type Backup struct {
+	// Document format version.
+	Version int `json:"version"`
+	// Source device address, 8 hex digits.
+	Address string `json:"address"`
+	// Source device firmware version.
+	Firmware uint16 `json:"firmware"`
+	// Time of backup creation.
+	Created time.Time `json:"created"`
+	// Daylight saving time switching.
+	DayLightSaving bool `json:"dst"`
+	// Pulse length in ms.
+	PulseLength float32 `json:"pulse_length"`
+	// Pause length in ms.
+	PauseLength float32 `json:"pause_length"`
+	// Serial line speed.
+	SerialSpeed uint32 `json:"serial_speed"`
+	// Serial line communication parameters, e.g. 8N1.
+	SerialConfig string `json:"serial_config"`
+	// Channels settings sorted by channel.
+	Channels []BackupChannel `json:"channels"`
+}
+
+// BackupChannel holds a single channel settings.
+type BackupChannel struct {
+	// Channel number.
+	Id uint `json:"channel"`
+	// Pulse weight.
+	PulseWeight float32 `json:"pulse_weight"`
+	// Current counter value.
+	Value float64 `json:"value"`
+}
+
+// Backup reads configuration, pulse weights and current values of chs channels.
+func (c *Client) Backup(chs ...uint) (*Backup, error) {
+	return c.BackupContext(context.Background(), chs...)
+}
+
+// BackupContext is like Backup but aborts the request when ctx is done.
+func (c *Client) BackupContext(ctx context.Context, chs ...uint) (*Backup, error) {
+	if err := validateChannels(chs...); err != nil {
+		return nil, err
+	}
+	cfg, err := c.ConfigContext(ctx, chs...)
+	if err != nil {
+		return nil, err
+	}
+	values, err := c.CurValuesContext(ctx, append([]uint(nil), chs...)...)
+	if err != nil {
+		return nil, err
+	}
+	b := &Backup{
+		Version:        BackupVersion,
+		Address:        fmt.Sprintf("%08x", cfg.Address),
+		Firmware:       cfg.Firmware,
+		Created:        time.Now(),
+		DayLightSaving: cfg.DayLightSaving,
+		PulseLength:    cfg.PulseLength,
+		PauseLength:    cfg.PauseLength,
+		SerialSpeed:    cfg.SerialSpeed,
+		SerialConfig:   cfg.SerialConfig.String(),
+	}
+	for i, w := range cfg.PulseWeights {
+		b.Channels = append(b.Channels, BackupChannel{Id: w.Id, PulseWeight: w.Value, Value: values[i].Value})
+	}
+	return b, nil
+}
+
+// WriteBackup encodes backup document as indented json.
+func WriteBackup(w io.Writer, b *Backup) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
+
+// ReadBackup decodes and validates backup document.
+func ReadBackup(r io.Reader) (*Backup, error) {
+	var b Backup
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&b); err != nil {
+		return nil, fmt.Errorf("invalid backup document: %w", err)
+	}
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Validate checks backup document version and values.
+func (b *Backup) Validate() error {
+	if b.Version != BackupVersion {
+		return fmt.Errorf("unsupported backup version %d", b.Version)
+	}
+	if _, err := parseAddress(b.Address); err != nil {
+		return fmt.Errorf("invalid address %q", b.Address)
+	}
+	if _, err := ParseSerialConfig(b.SerialConfig); err != nil {
+		return err
+	}
+	if b.SerialSpeed == 0 {
+		return fmt.Errorf("serial speed is missing")
+	}
+	if b.PulseLength <= 0 || b.PauseLength <= 0 {
+		return fmt.Errorf("pulse and pause length must be positive")
+	}
+	seen := make(map[uint]bool, len(b.Channels))
+	for _, ch := range b.Channels {
+		if ch.Id == 0 || ch.Id > maxChanNum {
+			return fmt.Errorf("invalid channel %d", ch.Id)
+		}
+		if seen[ch.Id] {
+			return fmt.Errorf("duplicate channel %d", ch.Id)
+		}
+		seen[ch.Id] = true
+	}
+	return nil
+}
+
+// config converts backup to device configuration.
+func (b *Backup) config() *DeviceConfig {
+	cfg := &DeviceConfig{
+		DayLightSaving: b.DayLightSaving,
+		PulseLength:    b.PulseLength,
+		PauseLength:    b.PauseLength,
+		SerialSpeed:    b.SerialSpeed,
+	}
+	cfg.SerialConfig, _ = ParseSerialConfig(b.SerialConfig)
+	for _, ch := range b.Channels {
+		cfg.PulseWeights = append(cfg.PulseWeights, PulseWeight{Id: ch.Id, Value: ch.PulseWeight})
+	}
+	return cfg
+}
+
+// RestoreDiff returns changes Restore would write to the device without writing them.
+func (c *Client) RestoreDiff(b *Backup) ([]ConfigChange, error) {
+	return c.RestoreDiffContext(context.Background(), b)
+}
+
+// RestoreDiffContext is like RestoreDiff but aborts the request when ctx is done.
+func (c *Client) RestoreDiffContext(ctx context.Context, b *Backup) ([]ConfigChange, error) {
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	return c.restoreDiff(ctx, b, false)
+}
+
+// Restore writes backup settings which differ from the device ones and reads them back for verification.
+// Counter values keep counting after the write, so values not less than the restored ones pass the verification.
+// Serial line settings are written last, the connection must follow them for the verification to succeed.
+// Returns written changes and ConfigError for failed or not verified fields.
+func (c *Client) Restore(b *Backup) ([]ConfigChange, error) {
+	return c.RestoreContext(context.Background(), b)
+}
+
+// RestoreContext is like Restore but aborts the request when ctx is done.
+func (c *Client) RestoreContext(ctx context.Context, b *Backup) ([]ConfigChange, error) {
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	changes, err := c.restoreDiff(ctx, b, false)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := c.applyChanges(ctx, changes)
+	errs, ok := err.(ConfigError)
+	if err != nil && !ok {
+		return applied, err
+	}
+	if errs == nil {
+		errs = make(ConfigError)
+	}
+
+	left, err := c.restoreDiff(ctx, b, true)
+	if err != nil {
+		return applied, err
+	}
+	for _, ch := range left {
+		if _, ok := errs[ch.Field]; !ok {
+			errs[ch.Field] = fmt.Errorf("verification failed: expected %v, got %v", ch.New, ch.Old)
+		}
+	}
+	if len(errs) > 0 {
+		return applied, errs
+	}
+	return applied, nil
+}
+
+// restoreDiff reads device settings and returns changes required to restore the backup.
+// If verify is true values greater than the backup ones are considered restored.
+func (c *Client) restoreDiff(ctx context.Context, b *Backup, verify bool) ([]ConfigChange, error) {
+	target := b.config()
+	chs := make([]uint, 0, len(b.Channels))
+	for _, ch := range b.Channels {
+		chs = append(chs, ch.Id)
+	}
+	cur, err := c.ConfigContext(ctx, chs...)
+	if err != nil {
+		return nil, err
+	}
+	changes := cur.Diff(target)
+	if len(chs) == 0 {
+		return changes, nil
+	}
+
+	values, err := c.CurValuesContext(ctx, chs...)
+	if err != nil {
+		return nil, err
+	}
+	cv := make(map[uint]float64, len(values))
+	for _, v := range values {
+		cv[v.Id] = v.Value
+	}
+	// serial line changes go last.
+	tail := len(changes)
+	for tail > 0 && (changes[tail-1].Field == FieldSerialConfig || changes[tail-1].Field == FieldSerialSpeed) {
+		tail--
+	}
+	serial := append([]ConfigChange(nil), changes[tail:]...)
+	changes = changes[:tail]
+	for _, ch := range b.Channels {
+		old := cv[ch.Id]
+		if old == ch.Value || verify && old > ch.Value {
+			continue
+		}
+		id, v := ch.Id, ch.Value
+		changes = append(changes, ConfigChange{fmt.Sprintf("%s[%d]", FieldValues, id), old, v,
+			func(ctx context.Context, c *Client) error { return c.SetCurValueContext(ctx, id, v) }})
+	}
+	return append(changes, serial...), nil
+}
@@ -0,0 +1,103 @@
+package pulsar_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+	"github.com/srgsf/tvh-pulsar/simulator"
+)
+
+func TestBackupRestore(t *testing.T) {
+	src, cl := startDevice(t, 0x00112233)
+	src.SetDayLightSaving(true)
+	src.SetPulseWeight(1, 0.1)
+	src.SetPulseWeight(2, 10)
+	src.SetValue(1, 123.5)
+	src.SetValue(2, 7)
+
+	b, err := cl.Backup(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Address != "00112233" || b.Firmware != 102 || len(b.Channels) != 2 {
+		t.Fatalf("unexpected backup %+v", b)
+	}
+	var buf bytes.Buffer
+	if err := pulsar.WriteBackup(&buf, b); err != nil {
+		t.Fatal(err)
+	}
+	b, err = pulsar.ReadBackup(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst, cl := startDevice(t, 0x00445566)
+	changes, err := cl.RestoreDiff(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{pulsar.FieldDayLightSaving, "PulseWeights[1]", "PulseWeights[2]", "Values[1]", "Values[2]"}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %v changes, got %v", expected, changes)
+	}
+	for i, ch := range changes {
+		if ch.Field != expected[i] {
+			t.Errorf("expected %s change, got %s", expected[i], ch.Field)
+		}
+	}
+	if dst.Requests(simulator.WriteSettings)+dst.Requests(simulator.WriteValue)+dst.Requests(simulator.WritePulseWeight) != 0 {
+		t.Error("dry run writes to device")
+	}
+
+	if _, err := cl.Restore(b); err != nil {
+		t.Fatal(err)
+	}
+	if !dst.DayLightSaving() || dst.PulseWeight(1) != 0.1 || dst.PulseWeight(2) != 10 ||
+		dst.Value(1) != 123.5 || dst.Value(2) != 7 {
+		t.Error("backup isn't restored")
+	}
+	if changes, err := cl.RestoreDiff(b); err != nil || len(changes) != 0 {
+		t.Errorf("unexpected changes after restore %v, %v", changes, err)
+	}
+}
+
+func TestRestoreFailure(t *testing.T) {
+	_, cl := startDevice(t, 1)
+	b, err := cl.Backup(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.PulseLength = 5
+	b.Channels[0].Value = 10
+
+	changes, err := cl.Restore(b)
+	var ce pulsar.ConfigError
+	if !errors.As(err, &ce) || len(ce) != 1 || ce[pulsar.FieldPulseLength] == nil {
+		t.Fatalf("expected pulse length failure, got %v", err)
+	}
+	if len(changes) != 1 || changes[0].Field != "Values[1]" {
+		t.Errorf("unexpected changes %v", changes)
+	}
+}
+
+func TestReadBackupValidation(t *testing.T) {
+	tests := []string{
+		`{"version": 2, "address": "00000001", "serial_speed": 9600, "serial_config": "8N1", "pulse_length": 100, "pause_length": 100}`,
+		`{"version": 1, "address": "x", "serial_speed": 9600, "serial_config": "8N1", "pulse_length": 100, "pause_length": 100}`,
+		`{"version": 1, "address": "00000001", "serial_speed": 9600, "serial_config": "7N1", "pulse_length": 100, "pause_length": 100}`,
+		`{"version": 1, "address": "00000001", "serial_speed": 9600, "serial_config": "8N1", "pulse_length": 0, "pause_length": 100}`,
+		`{"version": 1, "address": "00000001", "serial_speed": 9600, "serial_config": "8N1", "pulse_length": 100, "pause_length": 100,
+			"channels": [{"channel": 1}, {"channel": 1}]}`,
+		`{"version": 1, "address": "00000001", "serial_speed": 9600, "serial_config": "8N1", "pulse_length": 100, "pause_length": 100,
+			"channels": [{"channel": 17}]}`,
+		`{"version": 1, "unknown": true}`,
+	}
+	for _, doc := range tests {
+		if _, err := pulsar.ReadBackup(strings.NewReader(doc)); err == nil {
+			t.Errorf("error is expected for %s", doc)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package pulsar
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Bus is a set of devices that share a single connection, e.g. registrators daisy-chained on one rs485 line.
+// Bus hands out clients per device address and makes sure only a single frame exchange is on the wire at a time.
+// Bus is safe for concurrent use.
+type Bus struct {
+	// shared connection.
+	l *line
+	// options applied to every client.
+	opts []ClientOption
+
+	mu sync.Mutex
+	// clients by device address.
+	clients map[uint32]*Client
+	// communication statistics by device address.
+	stats map[uint32]*DeviceStats
+}
+
+// DeviceStats holds communication statistics of a device on a bus.
+type DeviceStats struct {
+	// Number of requests sent to a device.
+	Requests uint64
+	// Number of failed requests.
+	Errors uint64
+	// Number of requests failed due to i/o timeout.
+	Timeouts uint64
+	// Number of frames from a device received during other devices exchanges, e.g. late replies.
+	Stray uint64
+	// Number of late replies to previous requests discarded by message id.
+	Stale uint64
+	// Time of the last successful exchange.
+	LastSeen time.Time
+	// Error of the last failed request.
+	LastError error
+}
+
+// NewBus creates a bus on the connection. Options are applied to every client of the bus,
+// WithQueueLimit limits the queue of the shared connection.
+func NewBus(conn Conn, opts ...ClientOption) *Bus {
+	b := &Bus{
+		l:       newLine(conn),
+		opts:    opts,
+		clients: make(map[uint32]*Client),
+		stats:   make(map[uint32]*DeviceStats),
+	}
+	b.l.bus = b
+	// the queue is a setting of the line, so clients don't modify it.
+	b.l.q.limit = newClient(0, b.l, opts...).queueLimit
+	return b
+}
+
+// Client returns a client of a device with the address. A single client is created per address.
+func (b *Bus) Client(address string) (*Client, error) {
+	addr, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return b.client(addr), nil
+}
+
+// returns a client by numeric address.
+func (b *Bus) client(address uint32) *Client {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := b.clients[address]; ok {
+		return c
+	}
+	c := newClient(address, b.l, b.opts...)
+	b.clients[address] = c
+	if _, ok := b.stats[address]; !ok {
+		b.stats[address] = &DeviceStats{}
+	}
+	return c
+}
+
+// Clients returns clients of the bus ordered by device address.
+func (b *Bus) Clients() []*Client {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rv := make([]*Client, 0, len(b.clients))
+	for _, c := range b.clients {
+		rv = append(rv, c)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].address < rv[j].address })
+	return rv
+}
+
+// Reset replaces the connection of the bus once the exchange in progress is completed.
+func (b *Bus) Reset(conn Conn) {
+	b.l.reset(conn)
+}
+
+// Close closes the connection once the exchange in progress is completed.
+func (b *Bus) Close() error {
+	if err := b.l.q.acquire(context.Background()); err != nil {
+		return err
+	}
+	defer b.l.q.release()
+	return b.l.conn.Close()
+}
+
+// QueueLen returns the number of requests waiting for the connection.
+func (b *Bus) QueueLen() int {
+	return b.l.q.len()
+}
+
+// Stats returns communication statistics by device address.
+// Devices without a client are reported if they sent frames to the bus.
+func (b *Bus) Stats() map[uint32]DeviceStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rv := make(map[uint32]DeviceStats, len(b.stats))
+	for addr, s := range b.stats {
+		rv[addr] = *s
+	}
+	return rv
+}
+
+// record accounts request result for a device.
+func (b *Bus) record(address uint32, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.statsOf(address)
+	s.Requests++
+	if err == nil {
+		s.LastSeen = time.Now()
+		return
+	}
+	var pe *ProtocolError
+	if errors.As(err, &pe) {
+		// device is alive, but rejected the request.
+		s.LastSeen = time.Now()
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		s.Timeouts++
+	}
+	s.Errors++
+	s.LastError = err
+}
+
+// stray accounts a frame that was received from a device out of its exchange.
+func (b *Bus) stray(address uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.statsOf(address).Stray++
+}
+
+// staleReply accounts a late reply of a device discarded by message id.
+func (b *Bus) staleReply(address uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.statsOf(address).Stale++
+}
+
+// returns device statistics holder. Must be called with mu held.
+func (b *Bus) statsOf(address uint32) *DeviceStats {
+	s, ok := b.stats[address]
+	if !ok {
+		s = &DeviceStats{}
+		b.stats[address] = s
+	}
+	return s
+}
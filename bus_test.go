@@ -0,0 +1,137 @@
+package pulsar
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func createBus(t *testing.T, handler func(fn byte, payload []byte) []byte) (net.Conn, *Bus) {
+	dev, host := net.Pipe()
+	go serveDevice(dev, handler)
+	return dev, NewBus(newConn(host, nil, time.Second))
+}
+
+func TestBusClients(t *testing.T) {
+	dev, b := createBus(t, func(fn byte, payload []byte) []byte {
+		return []byte{0x66, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	})
+	defer func() { _ = dev.Close() }()
+
+	c1, err := b.Client("00000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, _ := b.Client("00000001")
+	if c, _ := b.Client("00000002"); c != c1 {
+		t.Error("client is not reused for the same address")
+	}
+	cls := b.Clients()
+	if len(cls) != 2 || cls[0] != c2 || cls[1] != c1 {
+		t.Error("clients aren't ordered by address")
+	}
+
+	var wg sync.WaitGroup
+	cnt := 10
+	wg.Add(2 * cnt)
+	for i := 0; i < cnt; i++ {
+		for _, c := range cls {
+			go func(c *Client) {
+				defer wg.Done()
+				if _, err := c.FirmwareVersion(); err != nil {
+					t.Error(err)
+				}
+			}(c)
+		}
+	}
+	wg.Wait()
+
+	st := b.Stats()
+	for _, addr := range []uint32{1, 2} {
+		if st[addr].Requests != uint64(cnt) || st[addr].Errors != 0 {
+			t.Errorf("wrong statistics for device %d: %+v", addr, st[addr])
+		}
+		if st[addr].LastSeen.IsZero() {
+			t.Errorf("last seen time isn't recorded for device %d", addr)
+		}
+	}
+}
+
+func TestBusStray(t *testing.T) {
+	dev, host := net.Pipe()
+	defer func() { _ = dev.Close() }()
+	go func() {
+		req := make([]byte, 12)
+		if _, err := dev.Read(req); err != nil {
+			return
+		}
+		stray := []byte{0x00, 0x00, 0x00, 0x03, 0x0A, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+		stray = append(stray, generateCRC(stray)...)
+		resp := append([]byte{}, req[:4]...)
+		resp = append(resp, 0x0A, 0x12, 0x66, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, req[8], req[9])
+		resp = append(resp, generateCRC(resp)...)
+		_, _ = dev.Write(append(stray, resp...))
+	}()
+	b := NewBus(newConn(host, nil, time.Second))
+	c, _ := b.Client("00000002")
+	v, err := c.FirmwareVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 102 {
+		t.Error("response decoding failed.")
+	}
+	if st := b.Stats()[3]; st.Stray != 1 {
+		t.Errorf("stray frame isn't accounted: %+v", st)
+	}
+}
+
+func TestBusErrors(t *testing.T) {
+	dev, host := net.Pipe()
+	defer func() { _ = dev.Close() }()
+	go func() {
+		req := make([]byte, 12)
+		if _, err := dev.Read(req); err != nil {
+			return
+		}
+		resp := append([]byte{}, req[:4]...)
+		resp = append(resp, 0x00, 0x0B, byte(IllegalAccess), req[8], req[9])
+		_, _ = dev.Write(append(resp, generateCRC(resp)...))
+	}()
+	b := NewBus(newConn(host, nil, 50*time.Millisecond))
+	c, _ := b.Client("00000002")
+	if _, err := c.FirmwareVersion(); err == nil {
+		t.Error("error response isn't reported")
+	}
+	if _, err := c.FirmwareVersion(); err == nil {
+		t.Error("timeout isn't reported")
+	}
+	st := b.Stats()[binary.BigEndian.Uint32([]byte{0, 0, 0, 2})]
+	if st.Requests != 2 || st.Errors != 2 || st.Timeouts != 1 || st.LastError == nil {
+		t.Errorf("wrong statistics: %+v", st)
+	}
+}
+
+func TestHighAddress(t *testing.T) {
+	var c mockConn
+	cl, err := NewClient("99999999", newConn(&c, nil, time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.Address() != 0x99999999 {
+		t.Error("address parsing failed")
+	}
+}
+
+func TestBusQueueLimit(t *testing.T) {
+	dev, host := net.Pipe()
+	defer func() { _ = dev.Close() }()
+	b := NewBus(newConn(host, nil, time.Second), WithQueueLimit(2))
+	c, _ := b.Client("00000001")
+	_, _ = b.Client("00000002")
+	if b.l.q.limit != 2 || c.q.limit != 2 {
+		t.Errorf("unexpected queue limit %d", b.l.q.limit)
+	}
+}
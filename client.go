@@ -2,8 +2,10 @@ package pulsar
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
 	"sort"
 	"strconv"
@@ -12,44 +14,116 @@ import (
 )
 
 // Client is a Pulsar network client handler that communicates with a device using pulsar data transmission protocol.
+// Client is safe for concurrent use, request and response exchanges are serialized.
 type Client struct {
-	// network connection
-	conn Conn
+	// number of discarded late replies, accessed atomically.
+	stale uint64
+	// non-zero if device rejects multi-channel archive requests, accessed atomically.
+	singleArchive int32
+	// whether archives of several channels are requested at once.
+	multiArchive bool
+	// cached device daylight saving setting, accessed atomically.
+	dst int32
+	// device clock location, nil if unknown.
+	loc *time.Location
 	// device address
 	address uint32
+	// connection shared with other devices on a bus.
+	*line
+	// retry policy of failed requests.
+	retry RetryPolicy
+	// observer of requests, may be nil.
+	observe RequestObserver
+	// limit of the line queue set by WithQueueLimit.
+	queueLimit int
+}
+
+// line is a connection that is shared by clients of devices on the same bus.
+type line struct {
+	// network connection
+	conn Conn
 	// message id generator. Holds next message id value.
 	ids uint32
+	// serializes frame exchanges on the connection.
+	q queue
+	// bus the line belongs to, nil for a standalone client.
+	bus *Bus
+}
+
+// ClientOption configures a Client.
+type ClientOption func(c *Client)
+
+// WithQueueLimit limits the number of requests waiting for the connection.
+// Requests beyond the limit fail with ErrQueueFull. Zero means unlimited.
+// Passed to NewBus it limits the queue shared by all clients of the bus.
+func WithQueueLimit(n int) ClientOption {
+	return func(c *Client) {
+		c.queueLimit = n
+	}
+}
+
+// WithMultiChannelArchive makes HourlyLogs, DailyLogs and MonthlyLogs request archives of all channels at once.
+// Protocol documentation describes single channel archive requests only, so the option is meant for devices
+// known to reply with values of channels in ascending order one after another.
+func WithMultiChannelArchive() ClientOption {
+	return func(c *Client) {
+		c.multiArchive = true
+	}
+}
+
+// RequestObserver is notified of every request attempt with device address, function name, e.g. "ReadValues",
+// time of the frame exchange and its error. Retries are reported as separate attempts.
+// It is called synchronously and must be safe for concurrent use.
+type RequestObserver func(address uint32, function string, elapsed time.Duration, err error)
+
+// WithObserver sets observer of client requests, e.g. to collect protocol metrics.
+func WithObserver(o RequestObserver) ClientOption {
+	return func(c *Client) {
+		c.observe = o
+	}
 }
 
 // Discover searches for pulsar meters in a local network and initialises Client if device is found.
 func Discover(conn Conn) (*Client, error) {
+	return DiscoverContext(context.Background(), conn)
+}
+
+// DiscoverContext is like Discover but aborts the search when ctx is done.
+func DiscoverContext(ctx context.Context, conn Conn) (*Client, error) {
 	if conn == nil {
 		return nil, fmt.Errorf("connection is required")
 	}
 
-	if err := conn.PrepareWrite(); err != nil {
-		return nil, err
-	}
-	if _, err := conn.Write(discoveryMessage); err != nil {
-		return nil, err
-	}
+	var response []byte
+	err := withContext(ctx, conn, func() error {
+		if err := conn.PrepareWrite(); err != nil {
+			return err
+		}
+		if _, err := conn.Write(discoveryMessage); err != nil {
+			return err
+		}
 
-	if err := conn.Flush(); err != nil {
-		return nil, err
-	}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
 
-	conn.LogRequest()
+		conn.LogRequest()
 
-	if err := conn.PrepareRead(); err != nil {
-		return nil, err
-	}
-	response := make([]byte, minFrameLen)
-	if _, err := conn.Read(response); err != nil {
+		if err := conn.PrepareRead(); err != nil {
+			return err
+		}
+		response = make([]byte, minFrameLen)
+		if _, err := io.ReadFull(conn, response); err != nil {
+			return err
+		}
+
+		conn.LogResponse()
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	conn.LogResponse()
-
 	if err := checkCrc(response); err != nil {
 		return nil, err
 	}
@@ -61,21 +135,66 @@ func Discover(conn Conn) (*Client, error) {
 }
 
 // NewClient creates a Client.
-func NewClient(address string, conn Conn) (*Client, error) {
-	i, err := strconv.ParseInt(address, 16, 32)
+func NewClient(address string, conn Conn, opts ...ClientOption) (*Client, error) {
+	i, err := parseAddress(address)
 	if err != nil {
 		return nil, err
 	}
-	return &Client{
-		conn:    conn,
-		address: uint32(i),
-		ids:     math.MaxUint32,
-	}, nil
+	c := newClient(i, newLine(conn), opts...)
+	c.q.limit = c.queueLimit
+	return c, nil
+}
+
+// parses device address in its hex (BCD) form, e.g. 12345678.
+func parseAddress(address string) (uint32, error) {
+	i, err := strconv.ParseUint(address, 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(i), nil
+}
+
+// creates a client of a device on the line.
+func newClient(address uint32, l *line, opts ...ClientOption) *Client {
+	c := &Client{
+		address: address,
+		line:    l,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// creates a line for the connection.
+func newLine(conn Conn) *line {
+	return &line{
+		conn: conn,
+		ids:  math.MaxUint32,
+	}
 }
 
 // Resets the connection for a client.
+// Waits for the exchange in progress to complete.
 func (c *Client) Reset(conn Conn) {
-	c.conn = conn
+	c.reset(conn)
+}
+
+// QueueLen returns the number of requests waiting for the connection.
+func (c *Client) QueueLen() int {
+	return c.q.len()
+}
+
+// StaleReplies returns the number of late replies to previous requests discarded by message id.
+func (c *Client) StaleReplies() uint64 {
+	return atomic.LoadUint64(&c.stale)
+}
+
+// replaces line's connection once the exchange in progress is completed.
+func (l *line) reset(conn Conn) {
+	_ = l.q.acquire(context.Background())
+	l.conn = conn
+	l.q.release()
 }
 
 // Address returns device's network address.
@@ -85,42 +204,55 @@ func (c *Client) Address() uint32 {
 
 // Model retrieves model id from a device. (No documentation is found for device id decoding)
 func (c *Client) Model() (uint16, error) {
+	return c.ModelContext(context.Background())
+}
+
+// ModelContext is like Model but aborts the request when ctx is done.
+func (c *Client) ModelContext(ctx context.Context) (uint16, error) {
 	request := make([]byte, 9)
 	binary.BigEndian.PutUint32(request, c.address)
 	copy(request[4:], discoveryModel)
-	if err := c.conn.PrepareWrite(); err != nil {
-		return 0, err
-	}
-
-	if err := c.writeMessage(request); err != nil {
-		return 0, err
-	}
 
-	for {
-		if err := c.conn.PrepareRead(); err != nil {
-			return 0, err
+	var model uint16
+	err := c.exchange(ctx, "Model", func() error {
+		if err := c.writeMessage(request); err != nil {
+			return err
 		}
 
-		response := make([]byte, minFrameLen)
-		if _, err := c.conn.Read(response); err != nil {
-			return 0, err
+		if err := c.conn.PrepareRead(); err != nil {
+			return err
 		}
+		for {
+			response := make([]byte, minFrameLen)
+			if _, err := io.ReadFull(c.conn, response); err != nil {
+				return err
+			}
 
-		if c.address != binary.BigEndian.Uint32(response) {
-			continue
-		}
+			if addr := binary.BigEndian.Uint32(response); addr != c.address {
+				c.conn.LogResponse()
+				c.stray(addr)
+				continue
+			}
 
-		c.conn.LogResponse()
-		if err := checkCrc(response); err != nil {
-			return 0, err
+			c.conn.LogResponse()
+			if err := checkCrc(response); err != nil {
+				return err
+			}
+			model = binary.BigEndian.Uint16(response[6:8])
+			return nil
 		}
-		return binary.BigEndian.Uint16(response[6:8]), nil
-	}
+	})
+	return model, err
 }
 
 // SysTime retrieves device's system time.
 func (c *Client) SysTime() (time.Time, error) {
-	data, err := c.command(fnReadSysTime, func() []byte {
+	return c.SysTimeContext(context.Background())
+}
+
+// SysTimeContext is like SysTime but aborts the request when ctx is done.
+func (c *Client) SysTimeContext(ctx context.Context) (time.Time, error) {
+	data, err := c.command(ctx, fnReadSysTime, func() []byte {
 		return nil
 	})
 	var st time.Time
@@ -131,12 +263,21 @@ func (c *Client) SysTime() (time.Time, error) {
 	if err = t.UnmarshalBinary(data); err != nil {
 		return st, err
 	}
-	return time.Time(t), nil
+	return c.fromDevice(ctx, time.Time(t))
 }
 
 // SetSysTime updates system time of the device.
 func (c *Client) SetSysTime(t time.Time) error {
-	data, err := c.command(fnWriteSysTime, func() []byte {
+	return c.SetSysTimeContext(context.Background(), t)
+}
+
+// SetSysTimeContext is like SetSysTime but aborts the request when ctx is done.
+func (c *Client) SetSysTimeContext(ctx context.Context, t time.Time) error {
+	t, err := c.toDevice(ctx, t)
+	if err != nil {
+		return err
+	}
+	data, err := c.command(ctx, fnWriteSysTime, func() []byte {
 		tm := sysTime(t)
 		rv, _ := tm.MarshalBinary()
 		return rv
@@ -175,11 +316,16 @@ func makeMask(chs ...uint) uint32 {
 
 // CurValues retrieves current values for channels. At least 1 channel number must be provided.
 func (c *Client) CurValues(chs ...uint) ([]Channel, error) {
+	return c.CurValuesContext(context.Background(), chs...)
+}
+
+// CurValuesContext is like CurValues but aborts the request when ctx is done.
+func (c *Client) CurValuesContext(ctx context.Context, chs ...uint) ([]Channel, error) {
 	if err := validateChannels(chs...); err != nil {
 		return nil, err
 	}
 	mask := makeMask(chs...)
-	data, err := c.command(fnReadValues, func() []byte {
+	data, err := c.command(ctx, fnReadValues, func() []byte {
 		rv := make([]byte, 4)
 		binary.LittleEndian.PutUint32(rv, mask)
 		return rv
@@ -208,11 +354,16 @@ func (c *Client) CurValues(chs ...uint) ([]Channel, error) {
 
 // SetCurValue updates current value for a channel.
 func (c *Client) SetCurValue(ch uint, val float64) error {
+	return c.SetCurValueContext(context.Background(), ch, val)
+}
+
+// SetCurValueContext is like SetCurValue but aborts the request when ctx is done.
+func (c *Client) SetCurValueContext(ctx context.Context, ch uint, val float64) error {
 	if ch == 0 {
 		return fmt.Errorf("channel must be non-zero")
 	}
 	wMask := uint32(1 << (ch - 1))
-	data, err := c.command(fnWriteValue, func() []byte {
+	data, err := c.command(ctx, fnWriteValue, func() []byte {
 		var b bytes.Buffer
 		_ = binary.Write(&b, binary.LittleEndian, wMask)
 		_ = binary.Write(&b, binary.LittleEndian, val)
@@ -230,11 +381,16 @@ func (c *Client) SetCurValue(ch uint, val float64) error {
 
 // PulseWeight retrieves pulse weights for channels. At least 1 channel number must be provided.
 func (c *Client) PulseWeight(chs ...uint) ([]PulseWeight, error) {
+	return c.PulseWeightContext(context.Background(), chs...)
+}
+
+// PulseWeightContext is like PulseWeight but aborts the request when ctx is done.
+func (c *Client) PulseWeightContext(ctx context.Context, chs ...uint) ([]PulseWeight, error) {
 	if err := validateChannels(chs...); err != nil {
 		return nil, err
 	}
 
-	data, err := c.command(fnReadPulseWeight, func() []byte {
+	data, err := c.command(ctx, fnReadPulseWeight, func() []byte {
 		mask := makeMask(chs...)
 		rv := make([]byte, 4)
 		binary.LittleEndian.PutUint32(rv, mask)
@@ -264,11 +420,16 @@ func (c *Client) PulseWeight(chs ...uint) ([]PulseWeight, error) {
 
 // SetPulseWeight updates pulse weight for a channel.
 func (c *Client) SetPulseWeight(ch uint, val float32) error {
+	return c.SetPulseWeightContext(context.Background(), ch, val)
+}
+
+// SetPulseWeightContext is like SetPulseWeight but aborts the request when ctx is done.
+func (c *Client) SetPulseWeightContext(ctx context.Context, ch uint, val float32) error {
 	if ch == 0 {
 		return fmt.Errorf("channel must be non-zero")
 	}
 	wMask := uint32(1 << (ch - 1))
-	data, err := c.command(fnWritePulseWeight, func() []byte {
+	data, err := c.command(ctx, fnWritePulseWeight, func() []byte {
 		var b bytes.Buffer
 		_ = binary.Write(&b, binary.LittleEndian, wMask)
 		_ = binary.Write(&b, binary.LittleEndian, val)
@@ -285,8 +446,8 @@ func (c *Client) SetPulseWeight(ch uint, val float32) error {
 }
 
 // Common function that retrieves configuration parameter's value.
-func (c *Client) param(name configParam) ([]byte, error) {
-	return c.command(fnReadSettings, func() []byte {
+func (c *Client) param(ctx context.Context, name configParam) ([]byte, error) {
+	return c.command(ctx, fnReadSettings, func() []byte {
 		rv := make([]byte, 2)
 		binary.LittleEndian.PutUint16(rv, uint16(name))
 		return rv
@@ -294,8 +455,8 @@ func (c *Client) param(name configParam) ([]byte, error) {
 }
 
 // Common function to update configuration parameter's value.
-func (c *Client) setParam(name configParam, value []byte) error {
-	data, err := c.command(fnWriteSettings, func() []byte {
+func (c *Client) setParam(ctx context.Context, name configParam, value []byte) error {
+	data, err := c.command(ctx, fnWriteSettings, func() []byte {
 		rv := make([]byte, 10)
 		binary.LittleEndian.PutUint16(rv, uint16(name))
 		copy(rv[2:], value)
@@ -315,30 +476,49 @@ func (c *Client) setParam(name configParam, value []byte) error {
 // DayLightSaving queries device if daylight saving enabled.
 // Returns true if enabled.
 func (c *Client) DayLightSaving() (bool, error) {
-	data, err := c.param(dayTimeSave)
-	rv := binary.LittleEndian.Uint64(data) & 0xFFFF
-	var value bool
-	if err != nil && rv != 0 {
-		value = true
+	return c.DayLightSavingContext(context.Background())
+}
+
+// DayLightSavingContext is like DayLightSaving but aborts the request when ctx is done.
+func (c *Client) DayLightSavingContext(ctx context.Context) (bool, error) {
+	data, err := c.param(ctx, dayTimeSave)
+	if err != nil {
+		return false, err
 	}
-	return value, err
+	dst := binary.LittleEndian.Uint16(data) != 0
+	c.setDST(dst)
+	return dst, nil
 }
 
 // SetDayLightSaving sets newValue as daylight saving param.
 // true means enabled.
 func (c *Client) SetDayLightSaving(newValue bool) error {
+	return c.SetDayLightSavingContext(context.Background(), newValue)
+}
+
+// SetDayLightSavingContext is like SetDayLightSaving but aborts the request when ctx is done.
+func (c *Client) SetDayLightSavingContext(ctx context.Context, newValue bool) error {
 	var nv uint64
 	if newValue {
 		nv = 1
 	}
 	b := make([]byte, 8)
 	binary.LittleEndian.PutUint64(b, nv)
-	return c.setParam(dayTimeSave, b)
+	if err := c.setParam(ctx, dayTimeSave, b); err != nil {
+		return err
+	}
+	c.setDST(newValue)
+	return nil
 }
 
 // PulseLength retrieves pulse length param value.
 func (c *Client) PulseLength() (float32, error) {
-	rv, err := c.param(pulseLength)
+	return c.PulseLengthContext(context.Background())
+}
+
+// PulseLengthContext is like PulseLength but aborts the request when ctx is done.
+func (c *Client) PulseLengthContext(ctx context.Context) (float32, error) {
+	rv, err := c.param(ctx, pulseLength)
 	if err != nil {
 		return 0., err
 	}
@@ -350,14 +530,24 @@ func (c *Client) PulseLength() (float32, error) {
 
 // SetPulseLength updates pulse length param value.
 func (c *Client) SetPulseLength(newValue float32) error {
+	return c.SetPulseLengthContext(context.Background(), newValue)
+}
+
+// SetPulseLengthContext is like SetPulseLength but aborts the request when ctx is done.
+func (c *Client) SetPulseLengthContext(ctx context.Context, newValue float32) error {
 	var b bytes.Buffer
 	_ = binary.Write(&b, binary.LittleEndian, float64(newValue))
-	return c.setParam(pulseLength, b.Bytes())
+	return c.setParam(ctx, pulseLength, b.Bytes())
 }
 
 // PauseLength retrieves pause length param value.
 func (c *Client) PauseLength() (float32, error) {
-	rv, err := c.param(pauseLength)
+	return c.PauseLengthContext(context.Background())
+}
+
+// PauseLengthContext is like PauseLength but aborts the request when ctx is done.
+func (c *Client) PauseLengthContext(ctx context.Context) (float32, error) {
+	rv, err := c.param(ctx, pauseLength)
 	if err != nil {
 		return 0., err
 	}
@@ -369,14 +559,24 @@ func (c *Client) PauseLength() (float32, error) {
 
 // SetPauseLength updates pause length param value.
 func (c *Client) SetPauseLength(newValue float32) error {
+	return c.SetPauseLengthContext(context.Background(), newValue)
+}
+
+// SetPauseLengthContext is like SetPauseLength but aborts the request when ctx is done.
+func (c *Client) SetPauseLengthContext(ctx context.Context, newValue float32) error {
 	var b bytes.Buffer
 	_ = binary.Write(&b, binary.LittleEndian, float64(newValue))
-	return c.setParam(pauseLength, b.Bytes())
+	return c.setParam(ctx, pauseLength, b.Bytes())
 }
 
 // FirmwareVersion retrieves current firmware version of a device.
 func (c *Client) FirmwareVersion() (uint16, error) {
-	rv, err := c.param(firmwareVer)
+	return c.FirmwareVersionContext(context.Background())
+}
+
+// FirmwareVersionContext is like FirmwareVersion but aborts the request when ctx is done.
+func (c *Client) FirmwareVersionContext(ctx context.Context) (uint16, error) {
+	rv, err := c.param(ctx, firmwareVer)
 	if err != nil {
 		return 0, err
 	}
@@ -384,18 +584,27 @@ func (c *Client) FirmwareVersion() (uint16, error) {
 }
 
 // DiagnosticsFlags retrieves self-check results.
-// 0x04 means EEPROM write error, 0x08 - negative current value in a channel.
-func (c *Client) DiagnosticsFlags() (uint8, error) {
-	rv, err := c.param(health)
+func (c *Client) DiagnosticsFlags() (Diagnostics, error) {
+	return c.DiagnosticsFlagsContext(context.Background())
+}
+
+// DiagnosticsFlagsContext is like DiagnosticsFlags but aborts the request when ctx is done.
+func (c *Client) DiagnosticsFlagsContext(ctx context.Context) (Diagnostics, error) {
+	rv, err := c.param(ctx, health)
 	if err != nil {
 		return 0, err
 	}
-	return rv[0], nil
+	return Diagnostics(rv[0]), nil
 }
 
 // SerialSpeed returns serial line speed configuration.
 func (c *Client) SerialSpeed() (uint32, error) {
-	rv, err := c.param(speed)
+	return c.SerialSpeedContext(context.Background())
+}
+
+// SerialSpeedContext is like SerialSpeed but aborts the request when ctx is done.
+func (c *Client) SerialSpeedContext(ctx context.Context) (uint32, error) {
+	rv, err := c.param(ctx, speed)
 	if err != nil {
 		return 0, err
 	}
@@ -405,15 +614,25 @@ func (c *Client) SerialSpeed() (uint32, error) {
 // SetSerialSpeed updates device serial line communication speed.
 // Possible values are: 1200..19200
 func (c *Client) SetSerialSpeed(newValue uint32) error {
+	return c.SetSerialSpeedContext(context.Background(), newValue)
+}
+
+// SetSerialSpeedContext is like SetSerialSpeed but aborts the request when ctx is done.
+func (c *Client) SetSerialSpeedContext(ctx context.Context, newValue uint32) error {
 	var b bytes.Buffer
 	_ = binary.Write(&b, binary.LittleEndian, uint64(newValue))
-	return c.setParam(speed, b.Bytes())
+	return c.setParam(ctx, speed, b.Bytes())
 }
 
 // SerialConfig retrieves encoded serial line communication parameters.
 func (c *Client) SerialConfig() (SerialConfig, error) {
+	return c.SerialConfigContext(context.Background())
+}
+
+// SerialConfigContext is like SerialConfig but aborts the request when ctx is done.
+func (c *Client) SerialConfigContext(ctx context.Context) (SerialConfig, error) {
 	var value SerialConfig
-	rv, err := c.param(serial)
+	rv, err := c.param(ctx, serial)
 	if err != nil {
 		return value, err
 	}
@@ -423,13 +642,18 @@ func (c *Client) SerialConfig() (SerialConfig, error) {
 
 // SetSerialConfig updates serial line communication parameters.
 func (c *Client) SetSerialConfig(newValue SerialConfig) error {
+	return c.SetSerialConfigContext(context.Background(), newValue)
+}
+
+// SetSerialConfigContext is like SetSerialConfig but aborts the request when ctx is done.
+func (c *Client) SetSerialConfigContext(ctx context.Context, newValue SerialConfig) error {
 	var b bytes.Buffer
 	_ = binary.Write(&b, binary.LittleEndian, uint64(newValue))
-	return c.setParam(serial, b.Bytes())
+	return c.setParam(ctx, serial, b.Bytes())
 }
 
 // common function for archive retrieval.
-func (c *Client) valuesLog(arch ArchType, ch uint, from, to sysTime) (*ChannelLog, error) {
+func (c *Client) valuesLog(ctx context.Context, arch ArchType, ch uint, from, to sysTime) (*ChannelLog, error) {
 	if ch == 0 {
 		return nil, fmt.Errorf("channel must be non-zero")
 	}
@@ -443,7 +667,7 @@ func (c *Client) valuesLog(arch ArchType, ch uint, from, to sysTime) (*ChannelLo
 	if err != nil {
 		return nil, err
 	}
-	data, err := c.command(fnReadArchive, func() []byte {
+	data, err := c.command(ctx, fnReadArchive, func() []byte {
 		var b bytes.Buffer
 		_ = binary.Write(&b, binary.LittleEndian, mask)
 		_ = binary.Write(&b, binary.LittleEndian, uint16(arch))
@@ -460,51 +684,82 @@ func (c *Client) valuesLog(arch ArchType, ch uint, from, to sysTime) (*ChannelLo
 	return chl, err
 }
 
-// HourlyLog retrieves hourly archive from device.
-func (c *Client) HourlyLog(ch uint, from, to time.Time) (*ChannelLog, error) {
-	start := sysTime(time.Date(from.Year(), from.Month(), from.Day(), from.Hour(), 0, 0, 0, from.Location()))
-	end := sysTime(time.Date(to.Year(), to.Month(), to.Day(), to.Hour(), 0, 0, 0, to.Location()))
-	l, err := c.valuesLog(Hourly, ch, start, end)
+// channelLog retrieves arch archive of the channel for HourlyLog, DailyLog and MonthlyLog.
+func (c *Client) channelLog(ctx context.Context, arch ArchType, ch uint, from, to time.Time) (*ChannelLog, error) {
+	loc, err := c.zone(ctx)
+	if err != nil {
+		return nil, err
+	}
+	start, end := logPeriod(arch, from, to, loc)
+	l, err := c.valuesLog(ctx, arch, ch, start, end)
 	if err != nil {
 		return nil, err
 	}
-	l.Type = Hourly
+	// reply holds the channel mask rather than the number.
+	l.Id, l.Type = ch, arch
+	if loc != nil {
+		l.inLocation(loc)
+	}
 	return l, nil
 }
 
+// logPeriod returns archive request period bounds for HourlyLog, DailyLog and MonthlyLog.
+// Bounds are converted to loc unless it is nil.
+func logPeriod(arch ArchType, from, to time.Time, loc *time.Location) (sysTime, sysTime) {
+	if loc != nil {
+		from, to = from.In(loc), to.In(loc)
+	}
+	start, end := arch.Truncate(from), arch.Truncate(to)
+	if arch == Monthly {
+		end = end.AddDate(0, 1, 0)
+	}
+	return sysTime(start), sysTime(end)
+}
+
+// HourlyLog retrieves hourly archive from device.
+func (c *Client) HourlyLog(ch uint, from, to time.Time) (*ChannelLog, error) {
+	return c.HourlyLogContext(context.Background(), ch, from, to)
+}
+
+// HourlyLogContext is like HourlyLog but aborts the request when ctx is done.
+func (c *Client) HourlyLogContext(ctx context.Context, ch uint, from, to time.Time) (*ChannelLog, error) {
+	return c.channelLog(ctx, Hourly, ch, from, to)
+}
+
 // DailyLog retrieves daily archive from device.
 func (c *Client) DailyLog(ch uint, from, to time.Time) (*ChannelLog, error) {
-	start := sysTime(time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location()))
-	end := sysTime(time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, to.Location()))
-	l, err := c.valuesLog(Daily, ch, start, end)
-	if err != nil {
-		return nil, err
-	}
-	l.Type = Daily
-	return l, nil
+	return c.DailyLogContext(context.Background(), ch, from, to)
+}
+
+// DailyLogContext is like DailyLog but aborts the request when ctx is done.
+func (c *Client) DailyLogContext(ctx context.Context, ch uint, from, to time.Time) (*ChannelLog, error) {
+	return c.channelLog(ctx, Daily, ch, from, to)
 }
 
 // MonthlyLog retrieves monthly archive from device.
 func (c *Client) MonthlyLog(ch uint, from, to time.Time) (*ChannelLog, error) {
-	start := sysTime(time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, from.Location()))
-	end := sysTime(time.Date(to.Year(), to.Month()+1, 1, 0, 0, 0, 0, to.Location()))
-	l, err := c.valuesLog(Monthly, ch, start, end)
-	if err != nil {
-		return nil, err
-	}
-	l.Type = Monthly
-	return l, nil
+	return c.MonthlyLogContext(context.Background(), ch, from, to)
+}
+
+// MonthlyLogContext is like MonthlyLog but aborts the request when ctx is done.
+func (c *Client) MonthlyLogContext(ctx context.Context, ch uint, from, to time.Time) (*ChannelLog, error) {
+	return c.channelLog(ctx, Monthly, ch, from, to)
 }
 
 // LineTest starts sensor test procedure.
 // This command suppress counting up to 200ms which can affect counting results.
 // See documentation for testing stand and bitmask result meaning.
 func (c *Client) LineTest(chs ...uint) (uint32, error) {
+	return c.LineTestContext(context.Background(), chs...)
+}
+
+// LineTestContext is like LineTest but aborts the request when ctx is done.
+func (c *Client) LineTestContext(ctx context.Context, chs ...uint) (uint32, error) {
 	if err := validateChannels(chs...); err != nil {
 		return 0, err
 	}
 	wMask := makeMask(chs...)
-	data, err := c.command(fnLineTest, func() []byte {
+	data, err := c.command(ctx, fnLineTest, func() []byte {
 		rv := make([]byte, 4)
 		binary.LittleEndian.PutUint32(rv, wMask)
 		return rv
@@ -518,11 +773,16 @@ func (c *Client) LineTest(chs ...uint) (uint32, error) {
 // InputTest retrieves sensor state for channels.
 // Returns a bitmask where 0s represent shorted sensors for a channel.
 func (c *Client) InputTest(chs ...uint) (uint32, error) {
+	return c.InputTestContext(context.Background(), chs...)
+}
+
+// InputTestContext is like InputTest but aborts the request when ctx is done.
+func (c *Client) InputTestContext(ctx context.Context, chs ...uint) (uint32, error) {
 	if err := validateChannels(chs...); err != nil {
 		return 0, err
 	}
 	wMask := makeMask(chs...)
-	data, err := c.command(fnInputTest, func() []byte {
+	data, err := c.command(ctx, fnInputTest, func() []byte {
 		rv := make([]byte, 4)
 		binary.LittleEndian.PutUint32(rv, wMask)
 		return rv
@@ -534,14 +794,68 @@ func (c *Client) InputTest(chs ...uint) (uint32, error) {
 }
 
 // id generator. Just adds a 1 to the next id.
-func (c *Client) nextId() uint16 {
-	atomic.AddUint32(&c.ids, 1)
-	return uint16(c.ids%math.MaxUint16) + 1
+func (l *line) nextId() uint16 {
+	id := atomic.AddUint32(&l.ids, 1)
+	return uint16(id%math.MaxUint16) + 1
+}
+
+// exchange runs a frame exchange bound to ctx retrying it according to the retry policy.
+func (c *Client) exchange(ctx context.Context, name string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := c.attempt(ctx, name, fn)
+		if err == nil || !c.retry.shouldRetry(attempt, err) {
+			return err
+		}
+		if err := sleep(ctx, c.retry.delay(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// attempt waits for exclusive access to the connection and runs a frame exchange bound to ctx.
+func (c *Client) attempt(ctx context.Context, name string, fn func() error) error {
+	if err := c.q.acquire(ctx); err != nil {
+		return err
+	}
+	defer c.q.release()
+	start := time.Now()
+	err := withContext(ctx, c.conn, fn)
+	if c.bus != nil {
+		c.bus.record(c.address, err)
+	}
+	if c.observe != nil {
+		c.observe(c.address, name, time.Since(start), err)
+	}
+	return err
+}
+
+// stray handles a frame addressed to another device.
+func (l *line) stray(address uint32) {
+	if l.bus != nil {
+		l.bus.stray(address)
+	}
+}
+
+// staleWindow is a number of ids preceding the request one which replies are treated as late.
+const staleWindow = 0x1000
+
+// isStale reports whether got is an id of one of the requests sent shortly before the one with id.
+func isStale(id, got uint16) bool {
+	d := (int(id) - int(got) + math.MaxUint16) % math.MaxUint16
+	return d > 0 && d <= staleWindow
+}
+
+// staleReply accounts a discarded late reply to a previous request.
+func (c *Client) staleReply() {
+	atomic.AddUint64(&c.stale, 1)
+	if c.bus != nil {
+		c.bus.staleReply(c.address)
+	}
 }
 
 // command encodes frame, sends to device, receives, decodes and validates responses.
 // Request and response message pattern  [address, function, length, payload, id, crc]
-func (c *Client) command(cmd byte, payload func() []byte) ([]byte, error) {
+func (c *Client) command(ctx context.Context, cmd byte, payload func() []byte) ([]byte, error) {
 	var b bytes.Buffer
 	_ = binary.Write(&b, binary.BigEndian, c.address)
 	_ = b.WriteByte(cmd)
@@ -552,11 +866,15 @@ func (c *Client) command(cmd byte, payload func() []byte) ([]byte, error) {
 	req := b.Bytes()
 	req[5] = byte(ln + minFrameLen)
 
-	if err := c.writeMessage(req); err != nil {
-		return nil, err
-	}
-
-	data, err := c.readMessage()
+	var data []byte
+	err := c.exchange(ctx, functionName(cmd), func() error {
+		if err := c.writeMessage(req); err != nil {
+			return err
+		}
+		var err error
+		data, err = c.readMessage(id)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -589,19 +907,20 @@ func (c *Client) writeMessage(request []byte) error {
 	if err == nil {
 		c.conn.LogRequest()
 	}
-	return nil
+	return err
 }
 
 // reads and validates incoming message.
-func (c *Client) readMessage() ([]byte, error) {
+// Replies to previous requests with the id within staleWindow are discarded.
+func (c *Client) readMessage(id uint16) ([]byte, error) {
 	rv, err := func(c *Client) ([]byte, error) {
+		if err := c.conn.PrepareRead(); err != nil {
+			return nil, err
+		}
 		for {
-			if err := c.conn.PrepareRead(); err != nil {
-				return nil, err
-			}
 			var cl = 6
 			response := make([]byte, cl)
-			if _, err := c.conn.Read(response); err != nil {
+			if _, err := io.ReadFull(c.conn, response); err != nil {
 				return nil, err
 			}
 
@@ -611,17 +930,27 @@ func (c *Client) readMessage() ([]byte, error) {
 			}
 			response = append(response[:cl], make([]byte, n)...)
 
-			if _, err := c.conn.Read(response[cl:]); err != nil {
+			if _, err := io.ReadFull(c.conn, response[cl:]); err != nil {
 				return nil, err
 			}
 
-			if c.address != binary.BigEndian.Uint32(response) {
+			if addr := binary.BigEndian.Uint32(response); addr != c.address {
+				c.conn.LogResponse()
+				c.stray(addr)
 				continue
 			}
 
 			if err := checkCrc(response); err != nil {
 				return nil, err
 			}
+			if got := binary.BigEndian.Uint16(response[len(response)-4:]); got != id {
+				if !isStale(id, got) {
+					return nil, &IdMismatchError{Expected: id, Got: got}
+				}
+				c.conn.LogResponse()
+				c.staleReply()
+				continue
+			}
 			if response[4] == fnError {
 				return nil, &ProtocolError{ErrorCode(response[6])}
 			}
@@ -635,6 +964,29 @@ func (c *Client) readMessage() ([]byte, error) {
 	return rv, err
 }
 
+// withContext runs a single frame exchange on conn bound to ctx.
+// If ctx is done during the exchange ctx.Err() is returned instead of the i/o error.
+func withContext(ctx context.Context, conn Conn, exchange func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if cc, ok := conn.(ContextConn); ok {
+		release := cc.Bind(ctx)
+		defer release()
+	}
+	err := exchange()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// i/o deadline may fire slightly before the context one.
+		if dl, ok := ctx.Deadline(); ok && !time.Now().Before(dl) {
+			return context.DeadlineExceeded
+		}
+	}
+	return err
+}
+
 // crc16 check.
 func checkCrc(response []byte) error {
 	ln := len(response) - 2
@@ -1,9 +1,13 @@
 package pulsar
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"net"
 	"os"
 	"sync"
 	"testing"
@@ -375,6 +379,47 @@ func TestMonthlyLog2(t *testing.T) {
 	checkRequest(t, req, exp)
 }
 
+func TestHourlyLogs(t *testing.T) {
+	var resp = []byte{
+		0x01, 0x02, 0x03, 0x04, 0x06, 0x24, 0x03, 0x00, 0x00, 0x00, 0x16, 0x09, 0x06, 0x0E, 0x00, 0x00,
+		0x00, 0x00, 0x80, 0x3F, 0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x40, 0x40, 0x00, 0x00, 0x80, 0x40,
+		0x00, 0x01,
+	}
+	from := time.Date(2022, time.September, 6, 14, 05, 06, 07, time.UTC)
+	to := time.Date(2022, time.September, 6, 15, 00, 00, 00, time.UTC)
+
+	c, cl := createMockClient(t)
+	WithMultiChannelArchive()(cl)
+	c.rBuf.Write(resp)
+	c.rBuf.Write(generateCRC(resp))
+
+	logs, err := cl.HourlyLogs(from, to, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 {
+		t.Fatal("response decoding failed, number of logs.")
+	}
+	for i, l := range logs {
+		if l.Id != uint(i+1) || l.Type != Hourly || len(l.Values) != 2 {
+			t.Errorf("response decoding failed, log %d.", i)
+		}
+		if l.Start != time.Date(2022, time.September, 6, 14, 0, 0, 0, time.UTC) {
+			t.Error("response decoding failed, Start field.")
+		}
+	}
+	if logs[0].Values[1] != 2 || logs[1].Values[0] != 3 {
+		t.Error("response decoding failed, Values field.")
+	}
+
+	var exp = []byte{
+		0x01, 0x02, 0x03, 0x04, 0x06, 0x1C, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00,
+		0x16, 0x09, 0x06, 0x0E, 0x00, 0x00, 0x16, 0x09, 0x06, 0x0F, 0x00, 0x00,
+		0x00, 0x01}
+	var req = c.wBuf.Bytes()
+	checkRequest(t, req[:len(exp)], exp)
+}
+
 func TestLineTest(t *testing.T) {
 	var resp = []byte{0x01, 0x02, 0x03, 0x04, 0x09, 0x0E, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
 	c, cl := createMockClient(t)
@@ -432,6 +477,20 @@ func TestDayLightSaving(t *testing.T) {
 	checkRequest(t, req, exp)
 }
 
+func TestDayLightSavingEnabled(t *testing.T) {
+	var resp = []byte{0x01, 0x02, 0x03, 0x04, 0x0A, 0x12, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+	c, cl := createMockClient(t)
+	c.rBuf.Write(resp)
+	c.rBuf.Write(generateCRC(resp))
+	l, err := cl.DayLightSaving()
+	if err != nil {
+		t.Error(err)
+	}
+	if l != true {
+		t.Error("response decoding failed.")
+	}
+}
+
 func TestSetDayLightSaving(t *testing.T) {
 	var resp = []byte{0x01, 0x02, 0x03, 0x04, 0x0B, 0x0C, 0x00, 0x00, 0x00, 0x01}
 	c, cl := createMockClient(t)
@@ -639,9 +698,10 @@ func TestSetSerialConfig(t *testing.T) {
 }
 
 func TestErrorResponse(t *testing.T) {
-	var resp = []byte{0x01, 0x02, 0x03, 0x04, 0x00, 0x0B, 0x02, 0x00, 0x02, 0x96, 0x18}
+	var resp = []byte{0x01, 0x02, 0x03, 0x04, 0x00, 0x0B, 0x02, 0x00, 0x01}
 	c, cl := createMockClient(t)
 	c.rBuf.Write(resp)
+	c.rBuf.Write(generateCRC(resp))
 	_, err := cl.SerialConfig()
 	if err == nil {
 		t.Error("response decoding failed.")
@@ -672,6 +732,151 @@ func TestMessageIds(t *testing.T) {
 	}
 }
 
+func TestIsStale(t *testing.T) {
+	tests := []struct {
+		id, got uint16
+		exp     bool
+	}{
+		{5, 4, true},
+		{5, 5, false},
+		{5, 6, false},
+		{1, 0xFFFF, true},
+		{0x9000, 0x1000, false},
+	}
+	for _, test := range tests {
+		if isStale(test.id, test.got) != test.exp {
+			t.Errorf("isStale(%d, %d) expected %v", test.id, test.got, test.exp)
+		}
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	c, cl := createMockClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := cl.SysTimeContext(ctx)
+	if err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	if c.wBuf.Len() != 0 {
+		t.Error("request is sent with canceled context")
+	}
+}
+
+func TestContextCancelAbortsExchange(t *testing.T) {
+	dev, host := net.Pipe()
+	defer func() { _ = dev.Close() }()
+	go func() { _, _ = io.Copy(io.Discard, dev) }()
+
+	cl, err := NewClient("01020304", newConn(host, nil, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = cl.CurValuesContext(ctx, 1)
+	if err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("exchange is not aborted on context cancellation")
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	dev, host := net.Pipe()
+	defer func() { _ = dev.Close() }()
+	go func() { _, _ = io.Copy(io.Discard, dev) }()
+
+	cl, err := NewClient("01020304", newConn(host, nil, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = cl.FirmwareVersionContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+// serveDevice emulates a device on conn, handler returns response payload for request's function and payload.
+func serveDevice(conn net.Conn, handler func(fn byte, payload []byte) []byte) {
+	for {
+		head := make([]byte, 6)
+		if _, err := io.ReadFull(conn, head); err != nil {
+			return
+		}
+		req := append(head, make([]byte, int(head[5])-len(head))...)
+		if _, err := io.ReadFull(conn, req[len(head):]); err != nil {
+			return
+		}
+		ln := len(req)
+		payload := handler(req[4], req[6:ln-4])
+		resp := append([]byte{}, req[:5]...)
+		resp = append(resp, byte(len(payload)+minFrameLen))
+		resp = append(resp, payload...)
+		resp = append(resp, req[ln-4:ln-2]...)
+		resp = append(resp, generateCRC(resp)...)
+		if _, err := conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+func TestConcurrentClient(t *testing.T) {
+	dev, host := net.Pipe()
+	defer func() { _ = dev.Close() }()
+	go serveDevice(dev, func(fn byte, payload []byte) []byte {
+		switch fn {
+		case fnReadSysTime:
+			return []byte{0x16, 0x09, 0x08, 0x00, 0x2F, 0x0A}
+		case fnReadSettings:
+			return []byte{0x66, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+		}
+		return nil
+	})
+
+	cl, err := NewClient("01020304", newConn(host, nil, 3*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	cnt := 20
+	wg.Add(2 * cnt)
+	for i := 0; i < cnt; i++ {
+		go func() {
+			defer wg.Done()
+			if _, err := cl.SysTime(); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			v, err := cl.FirmwareVersion()
+			if err != nil {
+				t.Error(err)
+			}
+			if v != 102 {
+				t.Error("response of concurrent request is mixed up.")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestQueueLimitOption(t *testing.T) {
+	_, cl := createMockClient(t)
+	cl, _ = NewClient("01020304", cl.conn, WithQueueLimit(3))
+	if cl.q.limit != 3 {
+		t.Error("queue limit option is not applied")
+	}
+	if cl.QueueLen() != 0 {
+		t.Error("queue is not empty")
+	}
+}
+
 func generateCRC(data []byte) []byte {
 	var res crc
 	res.reset()
@@ -680,3 +885,102 @@ func generateCRC(data []byte) []byte {
 	binary.LittleEndian.PutUint16(rv, uint16(res))
 	return rv
 }
+
+func TestInLocation(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	wall := time.Date(2021, 11, 7, 1, 30, 0, 0, time.UTC)
+	if tm := inLocation(wall, loc); !tm.Equal(time.Date(2021, 11, 7, 5, 30, 0, 0, time.UTC)) {
+		t.Errorf("ambiguous time resolved to %v", tm)
+	}
+
+	l := ChannelLog{Type: Hourly, Start: time.Date(2021, 11, 7, 0, 0, 0, 0, time.UTC), Values: []float32{1, 2, 3}}
+	l.inLocation(loc)
+	if !l.Start.Equal(time.Date(2021, 11, 7, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected start %v", l.Start)
+	}
+	expected := []float32{1, NoData, 2, 3}
+	if len(l.Values) != len(expected) {
+		t.Fatalf("expected %v got %v", expected, l.Values)
+	}
+	for i, v := range expected {
+		if math.Float32bits(l.Values[i]) != math.Float32bits(v) {
+			t.Errorf("value %d: expected %v got %v", i, v, l.Values[i])
+		}
+	}
+}
+
+func TestHourCrossing(t *testing.T) {
+	at := func(m, s int) time.Time { return time.Date(2022, 1, 1, 10, m, s, 0, time.UTC) }
+	tests := []struct {
+		dev, local time.Time
+		wait       time.Duration
+	}{
+		{at(30, 0), at(30, 20), 0},
+		{at(59, 30), at(58, 0), 3 * time.Minute},
+		{at(0, 30), at(30, 0), 30 * time.Second},
+		{at(59, 50), at(0, 10).Add(time.Hour), 70 * time.Second},
+	}
+	for i, test := range tests {
+		if wait := hourCrossing(test.dev, test.local, time.Minute); wait != test.wait {
+			t.Errorf("test %d: expected %v got %v", i, test.wait, wait)
+		}
+	}
+}
+
+func TestObserver(t *testing.T) {
+	c, cl := createMockClient(t)
+	var calls []string
+	var errs []error
+	cl.observe = func(address uint32, function string, elapsed time.Duration, err error) {
+		if address != 0x01020304 || elapsed < 0 {
+			t.Errorf("unexpected observation %08x %v", address, elapsed)
+		}
+		calls = append(calls, function)
+		errs = append(errs, err)
+	}
+	var resp = []byte{0x01, 0x02, 0x03, 0x04, 0x04, 0x10, 0x16, 0x09, 0x08, 0x00, 0x2F, 0x0A, 0x00, 0x01}
+	c.rBuf.Write(resp)
+	c.rBuf.Write(generateCRC(resp))
+	if _, err := cl.SysTime(); err != nil {
+		t.Error(err)
+	}
+	resp = []byte{0x01, 0x02, 0x03, 0x04, 0x00, 0x0B, 0x07, 0x00, 0x02}
+	c.rBuf.Write(resp)
+	c.rBuf.Write(generateCRC(resp))
+	if _, err := cl.FirmwareVersion(); err == nil {
+		t.Error("error isn't returned")
+	}
+	if len(calls) != 2 || calls[0] != "ReadSysTime" || calls[1] != "ReadSettings" {
+		t.Errorf("unexpected observations %v", calls)
+	}
+	if len(errs) != 2 || errs[0] != nil || errs[1] == nil {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
+
+func TestReadClockRoundTrip(t *testing.T) {
+	dev, host := net.Pipe()
+	defer func() { _ = dev.Close() }()
+	go serveDevice(dev, func(fn byte, payload []byte) []byte {
+		switch fn {
+		case fnReadSysTime:
+			return []byte{0x16, 0x09, 0x08, 0x00, 0x2F, 0x0A}
+		case fnReadSettings:
+			time.Sleep(200 * time.Millisecond)
+			return []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+		}
+		return nil
+	})
+
+	cl, _ := NewClient("01020304", newConn(host, nil, time.Second), WithLocation(time.UTC))
+	_, _, rtt, err := cl.readClock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtt >= 200*time.Millisecond {
+		t.Errorf("daylight saving request is included in round trip time %v", rtt)
+	}
+}
@@ -0,0 +1,204 @@
+// Command pulsar-exporter serves Pulsar-M pulse registrators metrics for Prometheus.
+//
+// Usage:
+//
+//	pulsar-exporter -tcp host:port -device 00112233:1-4 -device 00112234:1,2
+//
+// Run pulsar-exporter -h for the list of flags.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+	"github.com/srgsf/tvh-pulsar/exporter"
+)
+
+// command line options.
+type options struct {
+	// rs485 to Ethernet converter socket.
+	tcp string
+	// serial port device.
+	serial string
+	// serial port speed.
+	speed uint
+	// serial port configuration.
+	config string
+	// i/o timeout.
+	timeout time.Duration
+	// attempts of a request failed due to a transport failure.
+	retries int
+	// device clock time zone.
+	tz string
+	// polling interval.
+	interval time.Duration
+	// http listen address.
+	listen string
+	// metrics path.
+	path string
+	// polled devices.
+	devices devices
+}
+
+// target is a polled device.
+type target struct {
+	address  string
+	channels []uint
+}
+
+// devices is a repeatable flag of polled devices in the form address[:channels].
+type devices []target
+
+func (d *devices) String() string {
+	var b strings.Builder
+	for i, t := range *d {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.address)
+	}
+	return b.String()
+}
+
+func (d *devices) Set(s string) error {
+	t := target{address: s}
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		var err error
+		t.address = s[:i]
+		if t.channels, err = pulsar.ParseChannels(s[i+1:]); err != nil {
+			return err
+		}
+	}
+	*d = append(*d, t)
+	return nil
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "pulsar-exporter:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run parses arguments and serves metrics until ctx is done.
+func run(ctx context.Context, args []string, stderr io.Writer) error {
+	var o options
+	fs := flag.NewFlagSet("pulsar-exporter", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&o.tcp, "tcp", "", "rs485 to Ethernet converter socket, host:port")
+	fs.StringVar(&o.serial, "serial", "", "serial port device, e.g. /dev/ttyUSB0")
+	fs.UintVar(&o.speed, "speed", 9600, "serial port speed")
+	fs.StringVar(&o.config, "config", "8N1", "serial port configuration: 8N1, 8N2, 8O1, 8O2, 8E1, 8E2")
+	fs.DurationVar(&o.timeout, "timeout", 5*time.Second, "i/o timeout")
+	fs.IntVar(&o.retries, "retries", 3, "attempts of a request failed due to a transport failure, 1 disables retries")
+	fs.StringVar(&o.tz, "tz", "", "device clock time zone, e.g. Europe/Moscow")
+	fs.DurationVar(&o.interval, "interval", exporter.DefaultInterval, "polling interval")
+	fs.StringVar(&o.listen, "listen", ":9540", "http listen address")
+	fs.StringVar(&o.path, "path", "/metrics", "metrics path")
+	fs.Var(&o.devices, "device", "polled device address and channels, e.g. 00112233:1-4,7; repeat for several devices")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(o.devices) == 0 {
+		return fmt.Errorf("at least a single device is required, use -device flag")
+	}
+	logger := log.New(stderr, "", log.LstdFlags)
+	opts := []pulsar.ClientOption{pulsar.WithRetry(pulsar.RetryPolicy{
+		MaxAttempts: o.retries,
+		Backoff:     100 * time.Millisecond,
+		MaxBackoff:  o.timeout,
+		Jitter:      0.2,
+	})}
+	if o.tz != "" {
+		loc, err := time.LoadLocation(o.tz)
+		if err != nil {
+			return fmt.Errorf("invalid time zone: %w", err)
+		}
+		opts = append(opts, pulsar.WithLocation(loc))
+	}
+
+	conn, err := dial(o)
+	if err != nil {
+		return err
+	}
+	e := exporter.New(exporter.Options{Interval: o.interval, Logger: logger})
+	b := pulsar.NewBus(conn, append(opts, pulsar.WithObserver(e.ObserveRequest))...)
+	defer func() { _ = b.Close() }()
+	for _, t := range o.devices {
+		c, err := b.Client(t.address)
+		if err != nil {
+			return fmt.Errorf("invalid device address %q: %w", t.address, err)
+		}
+		e.Add(c, t.channels...)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(o.path, e)
+	srv := &http.Server{Addr: o.listen, Handler: mux}
+	errs := make(chan error, 1)
+	go func() { errs <- srv.ListenAndServe() }()
+	pctx, stop := context.WithCancel(ctx)
+	defer stop()
+	polled := make(chan error, 1)
+	go func() { polled <- e.Run(pctx) }()
+	logger.Printf("serving metrics at %s%s", o.listen, o.path)
+	select {
+	case err := <-errs:
+		stop()
+		<-polled
+		return err
+	case err = <-polled:
+		// stale metrics aren't served if polling stops.
+		if ctx.Err() != nil {
+			err = nil
+			break
+		}
+		err = fmt.Errorf("polling failed: %w", err)
+	case <-ctx.Done():
+		<-polled
+	}
+	sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if serr := srv.Shutdown(sctx); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// dial connects to devices according to options.
+func dial(o options) (pulsar.Conn, error) {
+	// connection is re-established after a failure as the exporter runs unattended.
+	d := pulsar.Dialer{
+		ConnectionTimeOut: o.timeout,
+		RWTimeOut:         o.timeout,
+		Redial:            true,
+	}
+	switch {
+	case o.tcp != "" && o.serial != "":
+		return nil, fmt.Errorf("either -tcp or -serial connection is allowed")
+	case o.tcp != "":
+		return d.DialTCP(o.tcp)
+	case o.serial != "":
+		cfg, err := pulsar.ParseSerialConfig(o.config)
+		if err != nil {
+			return nil, err
+		}
+		return d.DialSerial(o.serial, uint32(o.speed), cfg)
+	default:
+		return nil, fmt.Errorf("connection is required, use -tcp or -serial flag")
+	}
+}
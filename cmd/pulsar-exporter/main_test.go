@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+func TestDevicesFlag(t *testing.T) {
+	var d devices
+	for _, s := range []string{"00112233:1-3,7", "00000001"} {
+		if err := d.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(d) != 2 || d[0].address != "00112233" || d[1].address != "00000001" || len(d[1].channels) != 0 {
+		t.Fatalf("unexpected devices %+v", d)
+	}
+	exp := []uint{1, 2, 3, 7}
+	if len(d[0].channels) != len(exp) {
+		t.Fatalf("expected %v got %v", exp, d[0].channels)
+	}
+	for i, ch := range exp {
+		if d[0].channels[i] != ch {
+			t.Errorf("expected %v got %v", exp, d[0].channels)
+		}
+	}
+	for _, s := range []string{"1:0", "1:3-2", "1:x", "1:1-20", "1:17"} {
+		if err := d.Set(s); err == nil {
+			t.Errorf("%q is accepted", s)
+		}
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"-device", "00112233"},
+		{"-tcp", "127.0.0.1:1", "-serial", "/dev/null", "-device", "00112233"},
+	} {
+		if err := run(context.Background(), args, io.Discard); err == nil {
+			t.Errorf("%v: error isn't returned", args)
+		}
+	}
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+)
+
+// available commands.
+var commands = map[string]*command{
+	"discover": {
+		args:      "[window]",
+		help:      "find all devices on the line, window is a discovery replies collection duration",
+		noAddress: true,
+		run:       discover,
+	},
+	"values": {
+		args: "<channels>",
+		help: "read current channel values",
+		run:  values,
+	},
+	"set-value": {
+		args: "<channel> <value>",
+		help: "write current channel value",
+		run:  setValue,
+	},
+	"weights": {
+		args: "<channels>",
+		help: "read channel pulse weights",
+		run:  weights,
+	},
+	"set-weight": {
+		args: "<channel> <weight>",
+		help: "write channel pulse weight",
+		run:  setWeight,
+	},
+	"time": {
+		help: "read device clock",
+		run:  sysTime,
+	},
+	"sync-time": {
+		args: "[time]",
+		help: "write device clock, by default it is corrected if drift exceeds 5s and no hour boundary is crossed",
+		run:  syncTime,
+	},
+	"archive": {
+		args: "hourly|daily|monthly <channel> <from> <to>",
+		help: "read channel archive",
+		run:  archive,
+	},
+	"settings": {
+		args: "get | set <name> <value>",
+		help: "read or write settings: dst, pulse-length, pause-length, speed, serial",
+		run:  settings,
+	},
+	"line-test": {
+		args: "<channels>",
+		help: "run sensor line test, counting is suspended for up to 200ms",
+		run:  lineTest,
+	},
+	"sensor-check": {
+		args: "[channels]",
+		help: "run input and line tests, all 16 channels by default",
+		run:  sensorCheck,
+	},
+	"input-test": {
+		args: "<channels>",
+		help: "read sensor input states",
+		run:  inputTest,
+	},
+	"diag": {
+		help: "read self-check diagnostics flags",
+		run:  diag,
+	},
+	"health": {
+		args: "[channels]",
+		help: "check diagnostics flags, clock drift and input states of the channels",
+		run:  health,
+	},
+	"backup": {
+		args: "<channels>",
+		help: "export device configuration, use -format json to save a backup document",
+		run:  backup,
+	},
+	"restore": {
+		args: "<file> [apply]",
+		help: "show changes required to restore a backup document, apply writes them to the device",
+		run:  restore,
+	},
+}
+
+func discover(ctx context.Context, e *env, args []string) (*result, error) {
+	var opts pulsar.ScanOptions
+	if len(args) > 0 {
+		w, err := time.ParseDuration(args[0])
+		if err != nil {
+			return nil, err
+		}
+		opts.Window = w
+	}
+	devs, err := pulsar.Scan(ctx, e.conn, opts)
+	if err != nil {
+		return nil, err
+	}
+	type device struct {
+		Address  string `json:"address"`
+		Model    uint16 `json:"model"`
+		Firmware uint16 `json:"firmware"`
+	}
+	data := make([]device, 0, len(devs))
+	res := &result{header: []string{"ADDRESS", "MODEL", "FIRMWARE"}}
+	for _, d := range devs {
+		dev := device{fmt.Sprintf("%08x", d.Address), d.Model, d.Firmware}
+		data = append(data, dev)
+		res.rows = append(res.rows, []string{dev.Address, fmt.Sprintf("%04x", d.Model), fmt.Sprint(d.Firmware)})
+	}
+	res.data = data
+	return res, nil
+}
+
+func values(ctx context.Context, e *env, args []string) (*result, error) {
+	chs, err := parseChannels(args)
+	if err != nil {
+		return nil, err
+	}
+	vals, err := e.client.CurValuesContext(ctx, chs...)
+	if err != nil {
+		return nil, err
+	}
+	type value struct {
+		Channel uint    `json:"channel"`
+		Value   float64 `json:"value"`
+	}
+	data := make([]value, 0, len(vals))
+	res := &result{header: []string{"CHANNEL", "VALUE"}}
+	for _, v := range vals {
+		data = append(data, value{v.Id, v.Value})
+		res.rows = append(res.rows, []string{fmt.Sprint(v.Id), formatFloat(v.Value)})
+	}
+	res.data = data
+	return res, nil
+}
+
+func setValue(ctx context.Context, e *env, args []string) (*result, error) {
+	ch, v, err := parseChannelValue(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.client.SetCurValueContext(ctx, ch, v); err != nil {
+		return nil, err
+	}
+	return values(ctx, e, args[:1])
+}
+
+func weights(ctx context.Context, e *env, args []string) (*result, error) {
+	chs, err := parseChannels(args)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := e.client.PulseWeightContext(ctx, chs...)
+	if err != nil {
+		return nil, err
+	}
+	type weight struct {
+		Channel uint    `json:"channel"`
+		Weight  float32 `json:"weight"`
+	}
+	data := make([]weight, 0, len(ws))
+	res := &result{header: []string{"CHANNEL", "WEIGHT"}}
+	for _, w := range ws {
+		data = append(data, weight{w.Id, w.Value})
+		res.rows = append(res.rows, []string{fmt.Sprint(w.Id), formatFloat(float64(w.Value))})
+	}
+	res.data = data
+	return res, nil
+}
+
+func setWeight(ctx context.Context, e *env, args []string) (*result, error) {
+	ch, v, err := parseChannelValue(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.client.SetPulseWeightContext(ctx, ch, float32(v)); err != nil {
+		return nil, err
+	}
+	return weights(ctx, e, args[:1])
+}
+
+func sysTime(ctx context.Context, e *env, _ []string) (*result, error) {
+	t, drift, err := e.client.ClockDriftContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &result{
+		header: []string{"DEVICE TIME", "DRIFT"},
+		rows:   [][]string{{t.Format(time.RFC3339), drift.Round(time.Second).String()}},
+		data: struct {
+			Time  time.Time `json:"time"`
+			Drift float64   `json:"drift_seconds"`
+		}{t, drift.Seconds()},
+	}, nil
+}
+
+func syncTime(ctx context.Context, e *env, args []string) (*result, error) {
+	if len(args) == 0 {
+		opts := pulsar.SyncOptions{Logger: log.New(e.stderr, "", 0)}
+		if _, err := e.client.SyncTimeContext(ctx, opts); err != nil {
+			return nil, err
+		}
+		return sysTime(ctx, e, nil)
+	}
+	t, err := parseTime(args[0], e.loc)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.client.SetSysTimeContext(ctx, t); err != nil {
+		return nil, err
+	}
+	return sysTime(ctx, e, nil)
+}
+
+// archive types by name.
+var archives = map[string]pulsar.ArchType{
+	"hourly":  pulsar.Hourly,
+	"daily":   pulsar.Daily,
+	"monthly": pulsar.Monthly,
+}
+
+func archive(ctx context.Context, e *env, args []string) (*result, error) {
+	if len(args) != 4 {
+		return nil, fmt.Errorf("usage: archive hourly|daily|monthly <channel> <from> <to>")
+	}
+	typ, ok := archives[args[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown archive type %q", args[0])
+	}
+	chs, err := parseChannels(args[1:2])
+	if err != nil {
+		return nil, err
+	}
+	from, err := parseTime(args[2], e.loc)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseTime(args[3], e.loc)
+	if err != nil {
+		return nil, err
+	}
+	l, err := e.client.ArchiveContext(ctx, typ, chs[0], from, to, pulsar.ArchiveOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range l.Gaps {
+		fmt.Fprintf(e.stderr, "warning: no archive from %s to %s\n", g.From.Format(time.RFC3339), g.To.Format(time.RFC3339))
+	}
+
+	type record struct {
+		Time  time.Time `json:"time"`
+		Value *float32  `json:"value"`
+	}
+	data := make([]record, 0, len(l.Values))
+	res := &result{header: []string{"TIME", "VALUE"}}
+	for _, rec := range l.Records() {
+		r := record{Time: rec.Time}
+		var value string
+		if !pulsar.IsNoData(rec.Value) {
+			v := rec.Value
+			r.Value = &v
+			value = formatFloat(float64(v))
+		}
+		data = append(data, r)
+		res.rows = append(res.rows, []string{rec.Time.Format(time.RFC3339), value})
+	}
+	res.data = data
+	return res, nil
+}
+
+func settings(ctx context.Context, e *env, args []string) (*result, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("usage: settings get | set <name> <value>")
+	}
+	switch args[0] {
+	case "get":
+		return getSettings(ctx, e.client)
+	case "set":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("usage: settings set <name> <value>")
+		}
+		if err := setSetting(ctx, e.client, args[1], args[2]); err != nil {
+			return nil, err
+		}
+		return getSettings(ctx, e.client)
+	default:
+		return nil, fmt.Errorf("unknown settings action %q", args[0])
+	}
+}
+
+func getSettings(ctx context.Context, c *pulsar.Client) (*result, error) {
+	cfg, err := c.ConfigContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &result{
+		header: []string{"NAME", "VALUE"},
+		rows: [][]string{
+			{"dst", strconv.FormatBool(cfg.DayLightSaving)},
+			{"pulse-length", formatFloat(float64(cfg.PulseLength))},
+			{"pause-length", formatFloat(float64(cfg.PauseLength))},
+			{"speed", fmt.Sprint(cfg.SerialSpeed)},
+			{"serial", cfg.SerialConfig.String()},
+			{"firmware", fmt.Sprint(cfg.Firmware)},
+		},
+		data: struct {
+			DayLightSaving bool    `json:"dst"`
+			PulseLength    float32 `json:"pulse_length"`
+			PauseLength    float32 `json:"pause_length"`
+			Speed          uint32  `json:"speed"`
+			Serial         string  `json:"serial"`
+			Firmware       uint16  `json:"firmware"`
+		}{cfg.DayLightSaving, cfg.PulseLength, cfg.PauseLength, cfg.SerialSpeed, cfg.SerialConfig.String(), cfg.Firmware},
+	}, nil
+}
+
+func setSetting(ctx context.Context, c *pulsar.Client, name, value string) error {
+	switch name {
+	case "dst":
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		return c.SetDayLightSavingContext(ctx, v)
+	case "pulse-length", "pause-length":
+		v, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return err
+		}
+		if name == "pulse-length" {
+			return c.SetPulseLengthContext(ctx, float32(v))
+		}
+		return c.SetPauseLengthContext(ctx, float32(v))
+	case "speed":
+		v, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return err
+		}
+		return c.SetSerialSpeedContext(ctx, uint32(v))
+	case "serial":
+		v, err := pulsar.ParseSerialConfig(value)
+		if err != nil {
+			return err
+		}
+		return c.SetSerialConfigContext(ctx, v)
+	default:
+		return fmt.Errorf("unknown setting %q", name)
+	}
+}
+
+func lineTest(ctx context.Context, e *env, args []string) (*result, error) {
+	chs, err := parseChannels(args)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(e.stderr, "warning:", pulsar.LineTestWarning)
+	res, err := e.client.CheckLinesContext(ctx, chs...)
+	if err != nil {
+		return nil, err
+	}
+	return sensorResult(res), nil
+}
+
+func inputTest(ctx context.Context, e *env, args []string) (*result, error) {
+	chs, err := parseChannels(args)
+	if err != nil {
+		return nil, err
+	}
+	res, err := e.client.CheckInputsContext(ctx, chs...)
+	if err != nil {
+		return nil, err
+	}
+	return sensorResult(res), nil
+}
+
+func sensorCheck(ctx context.Context, e *env, args []string) (*result, error) {
+	var chs []uint
+	if len(args) > 0 {
+		var err error
+		if chs, err = parseChannels(args); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Fprintln(e.stderr, "warning:", pulsar.LineTestWarning)
+	sc, err := e.client.CheckSensorsContext(ctx, chs...)
+	if err != nil {
+		return nil, err
+	}
+	type state struct {
+		Channel uint   `json:"channel"`
+		Input   string `json:"input"`
+		Line    string `json:"line"`
+	}
+	var data []state
+	res := &result{header: []string{"CHANNEL", "INPUT", "LINE"}}
+	for _, ch := range sc.Inputs.Channels() {
+		s := state{ch, sc.Inputs.State(ch).String(), sc.Lines.State(ch).String()}
+		data = append(data, s)
+		res.rows = append(res.rows, []string{fmt.Sprint(ch), s.Input, s.Line})
+	}
+	res.data = data
+	return res, nil
+}
+
+// sensorResult formats per channel sensor test result.
+func sensorResult(t pulsar.SensorTest) *result {
+	type state struct {
+		Channel uint   `json:"channel"`
+		State   string `json:"state"`
+	}
+	var data []state
+	res := &result{header: []string{"CHANNEL", "STATE"}}
+	for _, ch := range t.Channels() {
+		s := state{ch, t.State(ch).String()}
+		data = append(data, s)
+		res.rows = append(res.rows, []string{fmt.Sprint(ch), s.State})
+	}
+	res.data = data
+	return res
+}
+
+func diag(ctx context.Context, e *env, _ []string) (*result, error) {
+	flags, err := e.client.DiagnosticsFlagsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &result{
+		header: []string{"FLAGS", "STATUS"},
+		rows:   [][]string{{fmt.Sprintf("%#02x", uint8(flags)), flags.String()}},
+		data: struct {
+			Flags    uint8    `json:"flags"`
+			Problems []string `json:"problems"`
+		}{uint8(flags), flags.Problems()},
+	}, nil
+}
+
+func health(ctx context.Context, e *env, args []string) (*result, error) {
+	var opts pulsar.HealthOptions
+	if len(args) > 0 {
+		chs, err := parseChannels(args)
+		if err != nil {
+			return nil, err
+		}
+		opts.Channels = chs
+	}
+	r, err := e.client.HealthContext(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	status := "OK"
+	if !r.Healthy() {
+		status = "FAIL"
+	}
+	problems := r.Problems()
+	res := &result{
+		header: []string{"CHECK", "RESULT"},
+		rows: [][]string{
+			{"status", status},
+			{"diagnostics", r.Diagnostics.String()},
+			{"device time", r.DeviceTime.Format(time.RFC3339)},
+			{"clock drift", r.ClockDrift.Round(time.Second).String()},
+		},
+		data: struct {
+			Healthy     bool      `json:"healthy"`
+			Diagnostics uint8     `json:"diagnostics"`
+			DeviceTime  time.Time `json:"device_time"`
+			Drift       float64   `json:"drift_seconds"`
+			Inputs      string    `json:"inputs,omitempty"`
+			Problems    []string  `json:"problems"`
+		}{r.Healthy(), uint8(r.Diagnostics), r.DeviceTime, r.ClockDrift.Seconds(), r.Inputs.String(), problems},
+	}
+	if len(opts.Channels) > 0 {
+		res.rows = append(res.rows, []string{"inputs", r.Inputs.String()})
+	}
+	for _, p := range problems {
+		res.rows = append(res.rows, []string{"problem", p})
+	}
+	return res, nil
+}
+
+func backup(ctx context.Context, e *env, args []string) (*result, error) {
+	chs, err := parseChannels(args)
+	if err != nil {
+		return nil, err
+	}
+	b, err := e.client.BackupContext(ctx, chs...)
+	if err != nil {
+		return nil, err
+	}
+	res := &result{
+		header: []string{"NAME", "VALUE"},
+		rows: [][]string{
+			{"address", b.Address},
+			{"firmware", fmt.Sprint(b.Firmware)},
+			{"dst", strconv.FormatBool(b.DayLightSaving)},
+			{"pulse-length", formatFloat(float64(b.PulseLength))},
+			{"pause-length", formatFloat(float64(b.PauseLength))},
+			{"speed", fmt.Sprint(b.SerialSpeed)},
+			{"serial", b.SerialConfig},
+		},
+		data: b,
+	}
+	for _, ch := range b.Channels {
+		res.rows = append(res.rows,
+			[]string{fmt.Sprintf("weight[%d]", ch.Id), formatFloat(float64(ch.PulseWeight))},
+			[]string{fmt.Sprintf("value[%d]", ch.Id), formatFloat(ch.Value)})
+	}
+	return res, nil
+}
+
+func restore(ctx context.Context, e *env, args []string) (*result, error) {
+	if len(args) == 0 || len(args) > 2 || len(args) == 2 && args[1] != "apply" {
+		return nil, fmt.Errorf("usage: restore <file> [apply]")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	b, err := pulsar.ReadBackup(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []pulsar.ConfigChange
+	if len(args) == 2 {
+		changes, err = e.client.RestoreContext(ctx, b)
+	} else {
+		changes, err = e.client.RestoreDiffContext(ctx, b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	type change struct {
+		Field string      `json:"field"`
+		Old   interface{} `json:"old"`
+		New   interface{} `json:"new"`
+	}
+	data := make([]change, 0, len(changes))
+	res := &result{header: []string{"FIELD", "DEVICE", "BACKUP"}}
+	for _, ch := range changes {
+		data = append(data, change{ch.Field, ch.Old, ch.New})
+		res.rows = append(res.rows, []string{ch.Field, fmt.Sprint(ch.Old), fmt.Sprint(ch.New)})
+	}
+	res.data = data
+	return res, nil
+}
+
+// parseChannels parses channel numbers and ranges, e.g. 1 2 5-8.
+func parseChannels(args []string) ([]uint, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("at least a single channel is required")
+	}
+	return pulsar.ParseChannels(strings.Join(args, ","))
+}
+
+// parseChannelValue parses channel number and value arguments.
+func parseChannelValue(args []string) (uint, float64, error) {
+	if len(args) != 2 {
+		return 0, 0, fmt.Errorf("channel and value are required")
+	}
+	chs, err := pulsar.ParseChannels(args[0])
+	if err != nil || len(chs) != 1 {
+		return 0, 0, fmt.Errorf("invalid channel %q", args[0])
+	}
+	v, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid value %q", args[1])
+	}
+	return chs[0], v, nil
+}
+
+// accepted time layouts.
+var layouts = []string{"2006-01-02", "2006-01-02T15:04", "2006-01-02T15:04:05"}
+
+// parseTime parses time in loc or RFC3339 format.
+func parseTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, l := range layouts {
+		if t, err := time.ParseInLocation(l, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// formatFloat formats value without trailing zeros.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
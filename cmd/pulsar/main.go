@@ -0,0 +1,190 @@
+// Command pulsar is a command line tool for Pulsar-M pulse registrators maintenance.
+//
+// Usage:
+//
+//	pulsar [flags] <command> [arguments]
+//
+// Run pulsar -h for the list of flags and commands.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+)
+
+// command line options.
+type options struct {
+	// rs485 to Ethernet converter socket.
+	tcp string
+	// serial port device.
+	serial string
+	// serial port speed.
+	speed uint
+	// serial port configuration.
+	config string
+	// device address.
+	address string
+	// i/o timeout.
+	timeout time.Duration
+	// output format.
+	format string
+	// enables protocol logging.
+	verbose bool
+	// device clock time zone.
+	tz string
+}
+
+// command is a tool subcommand.
+type command struct {
+	// arguments synopsis.
+	args string
+	// short description.
+	help string
+	// true if command doesn't require device address.
+	noAddress bool
+	// runs the command.
+	run func(ctx context.Context, e *env, args []string) (*result, error)
+}
+
+// env is a command execution environment.
+type env struct {
+	conn   pulsar.Conn
+	client *pulsar.Client
+	// warnings output.
+	stderr io.Writer
+	// location of time arguments.
+	loc *time.Location
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "pulsar:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run parses arguments and executes the command writing results to stdout.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var o options
+	fs := flag.NewFlagSet("pulsar", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&o.tcp, "tcp", "", "rs485 to Ethernet converter socket, host:port")
+	fs.StringVar(&o.serial, "serial", "", "serial port device, e.g. /dev/ttyUSB0")
+	fs.UintVar(&o.speed, "speed", 9600, "serial port speed")
+	fs.StringVar(&o.config, "config", "8N1", "serial port configuration: 8N1, 8N2, 8O1, 8O2, 8E1, 8E2")
+	fs.StringVar(&o.address, "addr", "", "device address, e.g. 12345678")
+	fs.DurationVar(&o.timeout, "timeout", 5*time.Second, "i/o timeout")
+	fs.StringVar(&o.format, "format", "table", "output format: table, json, csv")
+	fs.BoolVar(&o.verbose, "v", false, "log protocol frames to stderr")
+	fs.StringVar(&o.tz, "tz", "", "device clock time zone, e.g. Europe/Moscow")
+	fs.Usage = func() { usage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		return fmt.Errorf("unknown command %q", fs.Arg(0))
+	}
+	f, ok := formats[o.format]
+	if !ok {
+		return fmt.Errorf("unknown output format %q", o.format)
+	}
+	var opts []pulsar.ClientOption
+	loc := time.Local
+	if o.tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(o.tz); err != nil {
+			return fmt.Errorf("invalid time zone: %w", err)
+		}
+		opts = append(opts, pulsar.WithLocation(loc))
+	}
+
+	conn, err := dial(o, stderr)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	e := &env{conn: conn, stderr: stderr, loc: loc}
+	if !cmd.noAddress {
+		if o.address == "" {
+			return fmt.Errorf("device address is required, use -addr flag")
+		}
+		if e.client, err = pulsar.NewClient(o.address, conn, opts...); err != nil {
+			return fmt.Errorf("invalid device address: %w", err)
+		}
+	}
+	res, err := cmd.run(ctx, e, fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	return f(stdout, res)
+}
+
+// dial connects to devices according to options.
+func dial(o options, stderr io.Writer) (pulsar.Conn, error) {
+	d := pulsar.Dialer{
+		ConnectionTimeOut: o.timeout,
+		RWTimeOut:         o.timeout,
+	}
+	if o.verbose {
+		d.ProtocolLogger = log.New(stderr, "", log.Lmicroseconds)
+	}
+	switch {
+	case o.tcp != "" && o.serial != "":
+		return nil, fmt.Errorf("either -tcp or -serial connection is allowed")
+	case o.tcp != "":
+		return d.DialTCP(o.tcp)
+	case o.serial != "":
+		cfg, err := pulsar.ParseSerialConfig(o.config)
+		if err != nil {
+			return nil, err
+		}
+		return d.DialSerial(o.serial, uint32(o.speed), cfg)
+	default:
+		return nil, fmt.Errorf("connection is required, use -tcp or -serial flag")
+	}
+}
+
+// usage prints tool usage.
+func usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintln(w, "Usage: pulsar [flags] <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(w, "  %s\n    \t%s\n", strings.TrimSpace(name+" "+cmd.args), cmd.help)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Channels are listed as numbers or ranges, e.g. 1 2 5-8.")
+	fmt.Fprintln(w, "Time is formatted as 2006-01-02, 2006-01-02T15:04 or RFC3339, local time zone")
+	fmt.Fprintln(w, "or the one set by -tz flag is used by default.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Flags:")
+	fs.PrintDefaults()
+}
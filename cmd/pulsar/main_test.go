@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/srgsf/tvh-pulsar/simulator"
+)
+
+func startSimulator(t *testing.T) (*simulator.Simulator, string) {
+	t.Helper()
+	s := simulator.New()
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s, s.Addr().String()
+}
+
+func runCmd(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), args, &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestValues(t *testing.T) {
+	s, addr := startSimulator(t)
+	d := s.AddDevice(0x00112233)
+	d.SetValue(1, 12.5)
+	d.SetValue(3, 7)
+
+	out, err := runCmd(t, "-tcp", addr, "-addr", "00112233", "-format", "csv", "values", "1-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "CHANNEL,VALUE\n1,12.5\n2,0\n3,7\n"
+	if out != expected {
+		t.Errorf("expected %q got %q", expected, out)
+	}
+
+	out, err = runCmd(t, "-tcp", addr, "-addr", "00112233", "-format", "json", "set-value", "2", "4.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vals []struct {
+		Channel uint
+		Value   float64
+	}
+	if err := json.Unmarshal([]byte(out), &vals); err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 1 || vals[0].Channel != 2 || vals[0].Value != 4.25 {
+		t.Errorf("unexpected result %v", vals)
+	}
+	if d.Value(2) != 4.25 {
+		t.Errorf("value is not written %v", d.Value(2))
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	s, addr := startSimulator(t)
+	s.AddDevice(0x00112233)
+	s.AddDevice(0x00445566)
+
+	out, err := runCmd(t, "-tcp", addr, "discover", "100ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "00112233") || !strings.Contains(out, "00445566") {
+		t.Errorf("devices are not found:\n%s", out)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	s, addr := startSimulator(t)
+	d := s.AddDevice(0x00112233)
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	d.SetArchive(3, 1, start, []float32{1, 2, 3})
+
+	out, err := runCmd(t, "-tcp", addr, "-addr", "00112233", "-format", "csv",
+		"archive", "monthly", "1", "2021-03-01T00:00:00Z", "2021-06-01T00:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "TIME,VALUE\n2021-03-01T00:00:00Z,1\n2021-04-01T00:00:00Z,2\n2021-05-01T00:00:00Z,3\n2021-06-01T00:00:00Z,\n"
+	if out != expected {
+		t.Errorf("expected %q got %q", expected, out)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	s, addr := startSimulator(t)
+	d := s.AddDevice(0x00112233)
+
+	out, err := runCmd(t, "-tcp", addr, "-addr", "00112233", "settings", "set", "serial", "8E1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "8E1") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	if _, cfg := d.Serial(); cfg.String() != "8E1" {
+		t.Errorf("serial config is not written %v", cfg)
+	}
+}
+
+func TestInputTest(t *testing.T) {
+	s, addr := startSimulator(t)
+	d := s.AddDevice(0x00112233)
+	d.SetInputs(0x02)
+
+	out, err := runCmd(t, "-tcp", addr, "-addr", "00112233", "-format", "csv", "input-test", "1", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "CHANNEL,STATE\n1,shorted\n2,open\n"
+	if out != expected {
+		t.Errorf("expected %q got %q", expected, out)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	_, addr := startSimulator(t)
+	tests := [][]string{
+		{"-tcp", addr, "unknown"},
+		{"-tcp", addr, "values", "1"},
+		{"-tcp", addr, "-addr", "00112233", "-format", "xml", "values", "1"},
+		{"-addr", "00112233", "values", "1"},
+		{"-tcp", addr, "-addr", "00112233", "values", "x"},
+	}
+	for _, args := range tests {
+		if _, err := runCmd(t, args...); err == nil {
+			t.Errorf("error is expected for %v", args)
+		}
+	}
+}
+
+func TestParseChannels(t *testing.T) {
+	chs, err := parseChannels([]string{"1", "5-7", "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint{1, 5, 6, 7, 10}
+	if len(chs) != len(expected) {
+		t.Fatalf("expected %v got %v", expected, chs)
+	}
+	for i := range chs {
+		if chs[i] != expected[i] {
+			t.Errorf("expected %v got %v", expected, chs)
+		}
+	}
+	for _, s := range []string{"", "0", "17", "7-5", "a", "1-"} {
+		if _, err := parseChannels([]string{s}); err == nil {
+			t.Errorf("error is expected for %q", s)
+		}
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	s, addr := startSimulator(t)
+	src := s.AddDevice(0x00112233)
+	dst := s.AddDevice(0x00445566)
+	src.SetPulseWeight(1, 0.5)
+	src.SetValue(1, 42)
+
+	out, err := runCmd(t, "-tcp", addr, "-addr", "00112233", "-format", "json", "backup", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "backup.json")
+	if err := os.WriteFile(file, []byte(out), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err = runCmd(t, "-tcp", addr, "-addr", "00445566", "-format", "csv", "restore", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "FIELD,DEVICE,BACKUP\nPulseWeights[1],0.01,0.5\nValues[1],0,42\n"
+	if out != expected {
+		t.Errorf("expected %q got %q", expected, out)
+	}
+	if dst.Value(1) != 0 {
+		t.Error("dry run writes to device")
+	}
+
+	if _, err := runCmd(t, "-tcp", addr, "-addr", "00445566", "restore", file, "apply"); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Value(1) != 42 || dst.PulseWeight(1) != 0.5 {
+		t.Error("backup isn't restored")
+	}
+}
+
+func TestHealth(t *testing.T) {
+	s, addr := startSimulator(t)
+	d := s.AddDevice(0x00112233)
+	d.SetDiagnostics(0x08)
+
+	out, err := runCmd(t, "-tcp", addr, "-addr", "00112233", "health", "1-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "FAIL") || !strings.Contains(out, "negative channel value") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
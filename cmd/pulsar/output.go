@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// result is a command output.
+type result struct {
+	// table header.
+	header []string
+	// table rows.
+	rows [][]string
+	// value encoded in json format.
+	data interface{}
+}
+
+// formatter writes command result in a specific format.
+type formatter func(w io.Writer, r *result) error
+
+// supported output formats.
+var formats = map[string]formatter{
+	"table": writeTable,
+	"json":  writeJSON,
+	"csv":   writeCSV,
+}
+
+// writeTable writes result as an aligned text table.
+func writeTable(w io.Writer, r *result) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if len(r.header) > 0 {
+		fmt.Fprintln(tw, strings.Join(r.header, "\t"))
+	}
+	for _, row := range r.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// writeJSON writes result data as indented json.
+func writeJSON(w io.Writer, r *result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.data)
+}
+
+// writeCSV writes result as csv with a header line.
+func writeCSV(w io.Writer, r *result) error {
+	cw := csv.NewWriter(w)
+	if len(r.header) > 0 {
+		if err := cw.Write(r.header); err != nil {
+			return err
+		}
+	}
+	if err := cw.WriteAll(r.rows); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,265 @@
+package pulsar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// names of configuration fields used in ConfigError and ConfigChange.
+const (
+	FieldDayLightSaving = "DayLightSaving"
+	FieldPulseLength    = "PulseLength"
+	FieldPauseLength    = "PauseLength"
+	FieldFirmware       = "Firmware"
+	FieldDiagnostics    = "Diagnostics"
+	FieldSerialSpeed    = "SerialSpeed"
+	FieldSerialConfig   = "SerialConfig"
+	FieldPulseWeights   = "PulseWeights"
+	FieldTime           = "Time"
+	FieldValues         = "Values"
+)
+
+// DeviceConfig is a snapshot of device configuration parameters.
+type DeviceConfig struct {
+	// Device address.
+	Address uint32
+	// Daylight saving time switching.
+	DayLightSaving bool
+	// Pulse length in ms.
+	PulseLength float32
+	// Pause length in ms.
+	PauseLength float32
+	// Firmware version, read only.
+	Firmware uint16
+	// Self-check results, read only.
+	Diagnostics Diagnostics
+	// Serial line speed.
+	SerialSpeed uint32
+	// Serial line communication parameters.
+	SerialConfig SerialConfig
+	// Pulse weights of requested channels sorted by channel.
+	PulseWeights []PulseWeight
+	// Device clock at the moment of reading, read only.
+	Time time.Time
+}
+
+// ConfigError reports configuration fields failed to be read or written.
+// Keys are field names, e.g. FieldPulseLength or "PulseWeights[3]" for a single channel weight.
+type ConfigError map[string]error
+
+func (e ConfigError) Error() string {
+	fields := make([]string, 0, len(e))
+	for f := range e {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	var b strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		fmt.Fprintf(&b, "%s: %v", f, e[f])
+	}
+	return b.String()
+}
+
+// Config reads all configuration parameters and pulse weights of chs channels.
+// If some parameters are failed to be read the rest of config is returned along with ConfigError.
+func (c *Client) Config(chs ...uint) (*DeviceConfig, error) {
+	return c.ConfigContext(context.Background(), chs...)
+}
+
+// ConfigContext is like Config but aborts the request when ctx is done.
+func (c *Client) ConfigContext(ctx context.Context, chs ...uint) (*DeviceConfig, error) {
+	cfg := &DeviceConfig{Address: c.address}
+	errs := make(ConfigError)
+	read := func(field string, fn func() error) {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := fn(); err != nil {
+			errs[field] = err
+		}
+	}
+	read(FieldDayLightSaving, func() (err error) {
+		cfg.DayLightSaving, err = c.DayLightSavingContext(ctx)
+		return
+	})
+	read(FieldPulseLength, func() (err error) {
+		cfg.PulseLength, err = c.PulseLengthContext(ctx)
+		return
+	})
+	read(FieldPauseLength, func() (err error) {
+		cfg.PauseLength, err = c.PauseLengthContext(ctx)
+		return
+	})
+	read(FieldFirmware, func() (err error) {
+		cfg.Firmware, err = c.FirmwareVersionContext(ctx)
+		return
+	})
+	read(FieldDiagnostics, func() (err error) {
+		cfg.Diagnostics, err = c.DiagnosticsFlagsContext(ctx)
+		return
+	})
+	read(FieldSerialSpeed, func() (err error) {
+		cfg.SerialSpeed, err = c.SerialSpeedContext(ctx)
+		return
+	})
+	read(FieldSerialConfig, func() (err error) {
+		cfg.SerialConfig, err = c.SerialConfigContext(ctx)
+		return
+	})
+	if len(chs) > 0 {
+		read(FieldPulseWeights, func() (err error) {
+			cfg.PulseWeights, err = c.PulseWeightContext(ctx, append([]uint(nil), chs...)...)
+			return
+		})
+	}
+	read(FieldTime, func() (err error) {
+		cfg.Time, err = c.SysTimeContext(ctx)
+		return
+	})
+
+	if err := ctx.Err(); err != nil {
+		return cfg, err
+	}
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+	return cfg, nil
+}
+
+// ConfigChange is a writable configuration field that differs between two configs.
+type ConfigChange struct {
+	// Field name, e.g. FieldPulseLength or "PulseWeights[3]".
+	Field string
+	// Current value.
+	Old interface{}
+	// Target value.
+	New interface{}
+	// writes the target value.
+	apply func(ctx context.Context, c *Client) error
+}
+
+func (c ConfigChange) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.Field, c.Old, c.New)
+}
+
+// Diff returns writable fields of target which differ from cfg.
+// Read only fields, i.e. Firmware, Diagnostics and Time, are not compared.
+// Pulse weights are compared for target channels only.
+// Serial line settings are placed last since they affect further communication.
+func (cfg *DeviceConfig) Diff(target *DeviceConfig) []ConfigChange {
+	var rv []ConfigChange
+	if cfg.DayLightSaving != target.DayLightSaving {
+		v := target.DayLightSaving
+		rv = append(rv, ConfigChange{FieldDayLightSaving, cfg.DayLightSaving, v,
+			func(ctx context.Context, c *Client) error { return c.SetDayLightSavingContext(ctx, v) }})
+	}
+	if cfg.PulseLength != target.PulseLength {
+		v := target.PulseLength
+		rv = append(rv, ConfigChange{FieldPulseLength, cfg.PulseLength, v,
+			func(ctx context.Context, c *Client) error { return c.SetPulseLengthContext(ctx, v) }})
+	}
+	if cfg.PauseLength != target.PauseLength {
+		v := target.PauseLength
+		rv = append(rv, ConfigChange{FieldPauseLength, cfg.PauseLength, v,
+			func(ctx context.Context, c *Client) error { return c.SetPauseLengthContext(ctx, v) }})
+	}
+	weights := make(map[uint]float32, len(cfg.PulseWeights))
+	for _, w := range cfg.PulseWeights {
+		weights[w.Id] = w.Value
+	}
+	for _, w := range target.PulseWeights {
+		old, ok := weights[w.Id]
+		if ok && old == w.Value {
+			continue
+		}
+		var prev interface{}
+		if ok {
+			prev = old
+		}
+		ch, v := w.Id, w.Value
+		rv = append(rv, ConfigChange{fmt.Sprintf("%s[%d]", FieldPulseWeights, ch), prev, v,
+			func(ctx context.Context, c *Client) error { return c.SetPulseWeightContext(ctx, ch, v) }})
+	}
+	if cfg.SerialConfig != target.SerialConfig {
+		v := target.SerialConfig
+		rv = append(rv, ConfigChange{FieldSerialConfig, cfg.SerialConfig, v,
+			func(ctx context.Context, c *Client) error { return c.SetSerialConfigContext(ctx, v) }})
+	}
+	if cfg.SerialSpeed != target.SerialSpeed {
+		v := target.SerialSpeed
+		rv = append(rv, ConfigChange{FieldSerialSpeed, cfg.SerialSpeed, v,
+			func(ctx context.Context, c *Client) error { return c.SetSerialSpeedContext(ctx, v) }})
+	}
+	return rv
+}
+
+// ApplyConfig writes fields of cfg which differ from the device ones.
+// Returns changes that were written successfully and ConfigError for the failed ones.
+// Fields failed to be read from device aren't written and are reported in ConfigError.
+func (c *Client) ApplyConfig(cfg *DeviceConfig) ([]ConfigChange, error) {
+	return c.ApplyConfigContext(context.Background(), cfg)
+}
+
+// ApplyConfigContext is like ApplyConfig but aborts the request when ctx is done.
+func (c *Client) ApplyConfigContext(ctx context.Context, cfg *DeviceConfig) ([]ConfigChange, error) {
+	chs := make([]uint, 0, len(cfg.PulseWeights))
+	for _, w := range cfg.PulseWeights {
+		chs = append(chs, w.Id)
+	}
+	cur, err := c.ConfigContext(ctx, chs...)
+	var readErrs ConfigError
+	if err != nil && !errors.As(err, &readErrs) {
+		return nil, err
+	}
+	// current values of fields failed to be read are unknown, so their changes are skipped.
+	errs := make(ConfigError)
+	var changes []ConfigChange
+	for _, ch := range cur.Diff(cfg) {
+		field := ch.Field
+		if i := strings.IndexByte(field, '['); i >= 0 {
+			field = field[:i]
+		}
+		if err, ok := readErrs[field]; ok {
+			errs[ch.Field] = fmt.Errorf("reading failed: %w", err)
+			continue
+		}
+		changes = append(changes, ch)
+	}
+	applied, err := c.applyChanges(ctx, changes)
+	if len(errs) == 0 || ctx.Err() != nil {
+		return applied, err
+	}
+	if ce, ok := err.(ConfigError); ok {
+		for f, err := range ce {
+			errs[f] = err
+		}
+	}
+	return applied, errs
+}
+
+// applyChanges writes changes one by one collecting failures.
+func (c *Client) applyChanges(ctx context.Context, changes []ConfigChange) ([]ConfigChange, error) {
+	var applied []ConfigChange
+	errs := make(ConfigError)
+	for _, ch := range changes {
+		if err := ch.apply(ctx, c); err != nil {
+			if ctx.Err() != nil {
+				return applied, ctx.Err()
+			}
+			errs[ch.Field] = err
+			continue
+		}
+		applied = append(applied, ch)
+	}
+	if len(errs) > 0 {
+		return applied, errs
+	}
+	return applied, nil
+}
@@ -0,0 +1,178 @@
+package pulsar_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+	"github.com/srgsf/tvh-pulsar/simulator"
+)
+
+// startDevice serves a simulated device and returns a client connected to it.
+func startDevice(t *testing.T, address uint32, opts ...pulsar.ClientOption) (*simulator.Device, *pulsar.Client) {
+	s := simulator.New()
+	d := s.AddDevice(address)
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Skipf("tcp is not available: %v", err)
+	}
+	dl := pulsar.Dialer{RWTimeOut: time.Second}
+	conn, err := dl.DialTCP(s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		_ = s.Close()
+	})
+	cl, err := pulsar.NewClient(fmt.Sprintf("%08x", address), conn, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, cl
+}
+
+// startBus serves simulated devices and returns a bus connected to them.
+func startBus(t *testing.T, addresses ...uint32) (*simulator.Simulator, *pulsar.Bus) {
+	s := simulator.New()
+	for _, addr := range addresses {
+		s.AddDevice(addr)
+	}
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Skipf("tcp is not available: %v", err)
+	}
+	dl := pulsar.Dialer{RWTimeOut: 200 * time.Millisecond}
+	conn, err := dl.DialTCP(s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		_ = s.Close()
+	})
+	return s, pulsar.NewBus(conn)
+}
+
+func TestConfig(t *testing.T) {
+	d, cl := startDevice(t, 0x00112233)
+	d.SetDayLightSaving(true)
+	d.SetPulseWeight(2, 0.1)
+	d.SetDiagnostics(0x04)
+
+	cfg, err := cl.Config(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Address != 0x00112233 || !cfg.DayLightSaving || cfg.PulseLength != 100 || cfg.PauseLength != 100 ||
+		cfg.Firmware != 102 || cfg.Diagnostics != 0x04 || cfg.SerialSpeed != 9600 || cfg.SerialConfig != pulsar.Serial8N1 {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	if len(cfg.PulseWeights) != 2 || cfg.PulseWeights[0].Value != 0.01 || cfg.PulseWeights[1].Value != 0.1 {
+		t.Errorf("unexpected pulse weights %v", cfg.PulseWeights)
+	}
+	if cfg.Time.IsZero() {
+		t.Error("time isn't read")
+	}
+}
+
+func TestConfigPartialFailure(t *testing.T) {
+	d, cl := startDevice(t, 1)
+	d.InjectFaults(simulator.ReadSettings, simulator.Fault{Kind: simulator.ErrorReply, Code: pulsar.MissingParam, Skip: 1, Count: 1})
+
+	cfg, err := cl.Config()
+	var ce pulsar.ConfigError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected config error, got %v", err)
+	}
+	if len(ce) != 1 || ce[pulsar.FieldPulseLength] == nil {
+		t.Errorf("unexpected failures %v", ce)
+	}
+	if cfg.PauseLength != 100 || cfg.SerialSpeed != 9600 {
+		t.Errorf("rest of config isn't read %+v", cfg)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	d, cl := startDevice(t, 1)
+	cfg, err := cl.Config(1, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.DayLightSaving = true
+	cfg.PauseLength = 50
+	cfg.PulseWeights[2].Value = 10
+	cfg.SerialConfig = pulsar.Serial8E1
+
+	settings := d.Requests(simulator.WriteSettings)
+	changes, err := cl.ApplyConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{pulsar.FieldDayLightSaving, pulsar.FieldPauseLength, "PulseWeights[3]", pulsar.FieldSerialConfig}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %v changes, got %v", expected, changes)
+	}
+	for i, ch := range changes {
+		if ch.Field != expected[i] {
+			t.Errorf("expected %s change, got %s", expected[i], ch.Field)
+		}
+	}
+	if n := d.Requests(simulator.WriteSettings) - settings; n != 3 {
+		t.Errorf("expected 3 settings writes, got %d", n)
+	}
+	if !d.DayLightSaving() || d.PulseWeight(3) != 10 {
+		t.Error("config isn't applied")
+	}
+	if _, cfg := d.Serial(); cfg != pulsar.Serial8E1 {
+		t.Errorf("serial config isn't applied %v", cfg)
+	}
+
+	if changes, err = cl.ApplyConfig(cfg); err != nil || len(changes) != 0 {
+		t.Errorf("unexpected changes %v, %v", changes, err)
+	}
+}
+
+func TestApplyConfigFailure(t *testing.T) {
+	_, cl := startDevice(t, 1)
+	cfg, err := cl.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.PulseLength = 5
+	cfg.PauseLength = 200
+
+	changes, err := cl.ApplyConfig(cfg)
+	var ce pulsar.ConfigError
+	if !errors.As(err, &ce) || ce[pulsar.FieldPulseLength] == nil {
+		t.Fatalf("expected pulse length failure, got %v", err)
+	}
+	if len(changes) != 1 || changes[0].Field != pulsar.FieldPauseLength {
+		t.Errorf("unexpected changes %v", changes)
+	}
+}
+
+func TestApplyPartialConfig(t *testing.T) {
+	d, cl := startDevice(t, 1)
+	cfg, err := cl.Config(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.PulseLength = 50
+	cfg.PulseWeights[0].Value = 10
+	// diagnostics and pulse weights reads fail.
+	d.InjectFaults(simulator.ReadSettings, simulator.Fault{Kind: simulator.ErrorReply, Code: pulsar.MissingParam, Skip: 4, Count: 1})
+	d.InjectFaults(simulator.ReadPulseWeight, simulator.Fault{Kind: simulator.ErrorReply, Code: pulsar.MissingParam, Count: 1})
+
+	changes, err := cl.ApplyConfig(cfg)
+	var ce pulsar.ConfigError
+	if !errors.As(err, &ce) || len(ce) != 1 || ce["PulseWeights[1]"] == nil {
+		t.Fatalf("expected pulse weight failure, got %v", err)
+	}
+	if len(changes) != 1 || changes[0].Field != pulsar.FieldPulseLength {
+		t.Errorf("unexpected changes %v", changes)
+	}
+	if d.PulseWeight(1) == 10 {
+		t.Error("pulse weight is written")
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -41,6 +44,29 @@ const (
 	fnInputTest        byte = 0x19
 )
 
+// function names reported to RequestObserver.
+var functionNames = map[byte]string{
+	fnReadValues:       "ReadValues",
+	fnWriteValue:       "WriteValue",
+	fnReadSysTime:      "ReadSysTime",
+	fnWriteSysTime:     "WriteSysTime",
+	fnReadArchive:      "ReadArchive",
+	fnReadPulseWeight:  "ReadPulseWeight",
+	fnWritePulseWeight: "WritePulseWeight",
+	fnLineTest:         "LineTest",
+	fnReadSettings:     "ReadSettings",
+	fnWriteSettings:    "WriteSettings",
+	fnInputTest:        "InputTest",
+}
+
+// returns function name by its code.
+func functionName(fn byte) string {
+	if name, ok := functionNames[fn]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", fn)
+}
+
 // SerialConfig is a bitset that encodes different serial line configuration params.
 // Name contains number of bits, parity and stop bits number, e.g. Serial8N1 stands for 8 bits, parity: None, Stop bits: 1.
 type SerialConfig byte
@@ -54,6 +80,125 @@ const (
 	Serial8E2 SerialConfig = 200
 )
 
+// serial config bits.
+const (
+	serialTwoStopBits SerialConfig = 0x08
+	serialParity      SerialConfig = 0x80
+	serialEvenParity  SerialConfig = 0x40
+)
+
+// StopBits returns number of stop bits.
+func (s SerialConfig) StopBits() int {
+	if s&serialTwoStopBits != 0 {
+		return 2
+	}
+	return 1
+}
+
+// Parity returns parity mode: 'N' - none, 'O' - odd, 'E' - even.
+func (s SerialConfig) Parity() byte {
+	switch {
+	case s&serialParity == 0:
+		return 'N'
+	case s&serialEvenParity != 0:
+		return 'E'
+	default:
+		return 'O'
+	}
+}
+
+// String returns configuration in a conventional form, e.g. 8N1.
+func (s SerialConfig) String() string {
+	return fmt.Sprintf("8%c%d", s.Parity(), s.StopBits())
+}
+
+// ParseSerialConfig parses serial line configuration in a conventional form, e.g. 8N1 or 8e2.
+func ParseSerialConfig(s string) (SerialConfig, error) {
+	for _, c := range []SerialConfig{Serial8N1, Serial8N2, Serial8O1, Serial8O2, Serial8E1, Serial8E2} {
+		if strings.EqualFold(c.String(), s) {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported serial config: %s", s)
+}
+
+// ParseChannels parses comma separated channel numbers and ranges, e.g. 1,2,5-8.
+// Channels must be within 1..16.
+func ParseChannels(s string) ([]uint, error) {
+	var rv []uint
+	for _, arg := range strings.Split(s, ",") {
+		from, to := arg, arg
+		if i := strings.IndexByte(arg, '-'); i > 0 {
+			from, to = arg[:i], arg[i+1:]
+		}
+		f, err := strconv.ParseUint(from, 10, 8)
+		if err != nil || f == 0 || f > maxChanNum {
+			return nil, fmt.Errorf("invalid channel %q", arg)
+		}
+		t, err := strconv.ParseUint(to, 10, 8)
+		if err != nil || t < f || t > maxChanNum {
+			return nil, fmt.Errorf("invalid channel %q", arg)
+		}
+		for ch := f; ch <= t; ch++ {
+			rv = append(rv, uint(ch))
+		}
+	}
+	return rv, nil
+}
+
+// Diagnostics is a set of device self-check flags.
+type Diagnostics uint8
+
+const (
+	// DiagEEPROMWrite is set on EEPROM write error.
+	DiagEEPROMWrite Diagnostics = 0x04
+	// DiagNegativeValue is set when a current value of a channel is negative.
+	DiagNegativeValue Diagnostics = 0x08
+)
+
+// OK reports whether no flags are set.
+func (d Diagnostics) OK() bool {
+	return d == 0
+}
+
+// Has reports whether all flags of f are set.
+func (d Diagnostics) Has(f Diagnostics) bool {
+	return d&f == f
+}
+
+// EEPROMWriteError reports whether EEPROM write error flag is set.
+func (d Diagnostics) EEPROMWriteError() bool {
+	return d.Has(DiagEEPROMWrite)
+}
+
+// NegativeValue reports whether negative channel value flag is set.
+func (d Diagnostics) NegativeValue() bool {
+	return d.Has(DiagNegativeValue)
+}
+
+// Problems returns descriptions of the set flags.
+func (d Diagnostics) Problems() []string {
+	var rv []string
+	if d.EEPROMWriteError() {
+		rv = append(rv, "EEPROM write error")
+	}
+	if d.NegativeValue() {
+		rv = append(rv, "negative channel value")
+	}
+	if unknown := d &^ (DiagEEPROMWrite | DiagNegativeValue); unknown != 0 {
+		rv = append(rv, fmt.Sprintf("unknown flags %#02x", uint8(unknown)))
+	}
+	return rv
+}
+
+// String lists active problems or returns "ok" if there are none.
+func (d Diagnostics) String() string {
+	if d.OK() {
+		return "ok"
+	}
+	return strings.Join(d.Problems(), ", ")
+}
+
 // ErrorCode is a code returned by device on invalid request.
 type ErrorCode uint8
 
@@ -107,6 +252,19 @@ var ErrTooShort = errors.New("value too short")
 var ErrInvalidFrame = errors.New("invalid frame received")
 var ErrWriteFail = errors.New("write failed")
 
+// IdMismatchError is returned when response message id neither matches the request one
+// nor belongs to one of the recent requests, i.e. it is not a late reply.
+type IdMismatchError struct {
+	// Request message id.
+	Expected uint16
+	// Response message id.
+	Got uint16
+}
+
+func (e *IdMismatchError) Error() string {
+	return fmt.Sprintf("message id mismatch: expected %d, got %d", e.Expected, e.Got)
+}
+
 // configuration param encoded name.
 type configParam uint16
 
@@ -177,9 +335,23 @@ const (
 	Monthly
 )
 
+func (t ArchType) String() string {
+	switch t {
+	case Hourly:
+		return "hourly"
+	case Daily:
+		return "daily"
+	case Monthly:
+		return "monthly"
+	default:
+		return fmt.Sprintf("ArchType(%d)", uint8(t))
+	}
+}
+
 // ChannelLog is a response holder for archive values response.
 type ChannelLog struct {
-	// Number o channel.
+	// Number of channel. Device replies hold the channel mask, logs returned by Client hold the channel number,
+	// e.g. HourlyLog of channel 3 has Id 3 rather than mask 4.
 	Id uint
 	// Type of archive.
 	Type ArchType
@@ -187,6 +359,8 @@ type ChannelLog struct {
 	Start time.Time
 	// Archive values.
 	Values []float32
+	// Periods device has no archive for, filled by Archive only.
+	Gaps []Gap
 }
 
 func (l *ChannelLog) UnmarshalBinary(data []byte) error {
@@ -108,3 +108,73 @@ func TestCrc(t *testing.T) {
 		}
 	}
 }
+
+func TestSerialConfigString(t *testing.T) {
+	tests := map[SerialConfig]string{
+		Serial8N1: "8N1",
+		Serial8N2: "8N2",
+		Serial8O1: "8O1",
+		Serial8O2: "8O2",
+		Serial8E1: "8E1",
+		Serial8E2: "8E2",
+	}
+	for cfg, exp := range tests {
+		if cfg.String() != exp {
+			t.Errorf("expected %s, got %s", exp, cfg.String())
+		}
+	}
+}
+
+func TestParseSerialConfig(t *testing.T) {
+	cfg, err := ParseSerialConfig("8e2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg != Serial8E2 {
+		t.Errorf("expected %s, got %s", Serial8E2, cfg)
+	}
+	if _, err := ParseSerialConfig("7N1"); err == nil {
+		t.Error("unsupported config is parsed")
+	}
+}
+
+func TestParseChannels(t *testing.T) {
+	chs, err := ParseChannels("1,5-7,16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint{1, 5, 6, 7, 16}
+	if len(chs) != len(expected) {
+		t.Fatalf("expected %v got %v", expected, chs)
+	}
+	for i := range chs {
+		if chs[i] != expected[i] {
+			t.Errorf("expected %v got %v", expected, chs)
+		}
+	}
+	for _, s := range []string{"", "0", "17", "1-20", "7-5", "a", "1-"} {
+		if _, err := ParseChannels(s); err == nil {
+			t.Errorf("error is expected for %q", s)
+		}
+	}
+}
+
+func TestDiagnosticsString(t *testing.T) {
+	tests := []struct {
+		d   Diagnostics
+		exp string
+	}{
+		{0, "ok"},
+		{DiagEEPROMWrite, "EEPROM write error"},
+		{DiagEEPROMWrite | DiagNegativeValue, "EEPROM write error, negative channel value"},
+		{0x01 | DiagNegativeValue, "negative channel value, unknown flags 0x01"},
+	}
+	for _, test := range tests {
+		if test.d.String() != test.exp {
+			t.Errorf("expected %q got %q", test.exp, test.d.String())
+		}
+	}
+	if d := DiagNegativeValue; d.OK() || d.EEPROMWriteError() || !d.NegativeValue() {
+		t.Error("predicates failed")
+	}
+}
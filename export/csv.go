@@ -0,0 +1,72 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+)
+
+// csvHeader is the first line of CSV output.
+var csvHeader = []string{"address", "channel", "type", "time", "value"}
+
+// CSVWriter writes records as CSV lines with a header, e.g.
+//
+//	address,channel,type,time,value
+//	00112233,1,hourly,2022-07-01T00:00:00+03:00,12.5
+//
+// Missing archive records have empty value.
+type CSVWriter struct {
+	w *csv.Writer
+	// whether the header is written.
+	started bool
+}
+
+// NewCSVWriter creates a CSV writer.
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w)}
+}
+
+// WriteLog writes archive records of the channel log.
+func (w *CSVWriter) WriteLog(address uint32, l *pulsar.ChannelLog) error {
+	for i, v := range l.Values {
+		value := ""
+		if !pulsar.IsNoData(v) {
+			value = strconv.FormatFloat(float64(v), 'f', -1, 32)
+		}
+		if err := w.record(address, l.Id, l.Type.String(), l.Time(i), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteValues writes current values read at t.
+func (w *CSVWriter) WriteValues(address uint32, t time.Time, values []pulsar.Channel) error {
+	for _, v := range values {
+		if err := w.record(address, v.Id, Current, t, strconv.FormatFloat(v.Value, 'f', -1, 64)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes buffered lines to the underlying writer.
+func (w *CSVWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// record writes a single line, the header is written before the first one.
+func (w *CSVWriter) record(address uint32, ch uint, typ string, t time.Time, value string) error {
+	if !w.started {
+		if err := w.w.Write(csvHeader); err != nil {
+			return err
+		}
+		w.started = true
+	}
+	return w.w.Write([]string{fmt.Sprintf("%08x", address), strconv.Itoa(int(ch)), typ, t.Format(time.RFC3339Nano), value})
+}
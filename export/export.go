@@ -0,0 +1,52 @@
+// Package export writes archive records and current values of Pulsar devices
+// in InfluxDB line protocol and CSV formats.
+//
+// Writers stream records to the underlying writer, WriteArchive writes archives of devices channel by channel.
+package export
+
+import (
+	"context"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+)
+
+// Current is a type tag value of current values.
+const Current = "current"
+
+// Writer writes device readings.
+// Records are tagged with device address, channel and archive type, current values are tagged with Current type.
+type Writer interface {
+	// WriteLog writes archive records of the channel log with their timestamps.
+	WriteLog(address uint32, l *pulsar.ChannelLog) error
+	// WriteValues writes current values read at t.
+	WriteValues(address uint32, t time.Time, values []pulsar.Channel) error
+	// Flush writes buffered data to the underlying writer.
+	Flush() error
+}
+
+// WriteArchive retrieves typ archive records of chs channels within [from, to] range and writes them to w.
+// Archive of a channel is retrieved with pulsar.Client.ArchiveContext and the writer is flushed after it is written.
+// If a request fails records fetched so far are written before the error is returned.
+func WriteArchive(ctx context.Context, c *pulsar.Client, w Writer, typ pulsar.ArchType, from, to time.Time, chs ...uint) error {
+	for _, ch := range chs {
+		l, err := c.ArchiveContext(ctx, typ, ch, from, to, pulsar.ArchiveOptions{})
+		if l != nil && len(l.Values) > 0 {
+			if err := writeLog(w, c.Address(), l); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLog writes the log and flushes the writer.
+func writeLog(w Writer, address uint32, l *pulsar.ChannelLog) error {
+	if err := w.WriteLog(address, l); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,148 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+	"github.com/srgsf/tvh-pulsar/simulator"
+)
+
+func TestInfluxWriter(t *testing.T) {
+	var b bytes.Buffer
+	w, err := NewInfluxWriter(&b, InfluxOptions{Precision: time.Second, Tags: map[string]string{"site": "house 1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2022, 1, 31, 0, 0, 0, 0, time.UTC)
+	l := &pulsar.ChannelLog{Id: 2, Type: pulsar.Monthly, Start: start, Values: []float32{1.1, pulsar.NoData, 3}}
+	if err := w.WriteLog(0x00112233, l); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteValues(1, start, []pulsar.Channel{{Id: 1, Value: 0.5}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf(`pulsar,site=house\ 1,address=00112233,channel=2,type=monthly value=1.1 %d
+pulsar,site=house\ 1,address=00112233,channel=2,type=monthly value=3 %d
+pulsar,site=house\ 1,address=00000001,channel=1,type=current value=0.5 %d
+`, start.Unix(), start.AddDate(0, 2, 0).Unix(), start.Unix())
+	if b.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+
+	if _, err := NewInfluxWriter(&b, InfluxOptions{Tags: map[string]string{"channel": "1"}}); err == nil {
+		t.Error("reserved tag is accepted")
+	}
+	if _, err := NewInfluxWriter(&b, InfluxOptions{Precision: time.Minute}); err == nil {
+		t.Error("invalid precision is accepted")
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := NewCSVWriter(&b)
+	start := time.Date(2022, 3, 27, 1, 0, 0, 0, time.FixedZone("CET", 3600))
+	l := &pulsar.ChannelLog{Id: 1, Type: pulsar.Hourly, Start: start, Values: []float32{1, pulsar.NoData}}
+	if err := w.WriteLog(0x00112233, l); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "address,channel,type,time,value\n" +
+		"00112233,1,hourly,2022-03-27T01:00:00+01:00,1\n" +
+		"00112233,1,hourly,2022-03-27T02:00:00+01:00,\n"
+	if b.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	s := simulator.New()
+	d := s.AddDevice(1)
+	d.SetLocation(time.Local)
+	d.SetDayLightSaving(true)
+	start := time.Date(2022, 1, 10, 0, 0, 0, 0, time.Local)
+	values := make([]float32, 100)
+	for i := range values {
+		values[i] = float32(i)
+	}
+	d.SetArchive(pulsar.Hourly, 1, start, values)
+	d.SetArchive(pulsar.Hourly, 3, start, values[:10])
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Skipf("tcp is not available: %v", err)
+	}
+	dl := pulsar.Dialer{RWTimeOut: time.Second}
+	conn, err := dl.DialTCP(s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+		_ = s.Close()
+	}()
+	c, _ := pulsar.NewClient("00000001", conn)
+
+	var b bytes.Buffer
+	if err := WriteArchive(context.Background(), c, NewCSVWriter(&b), pulsar.Hourly, start, start.Add(99*time.Hour), 1, 3); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 201 {
+		t.Fatalf("unexpected number of lines %d", len(lines))
+	}
+	if !strings.HasPrefix(lines[100], "00000001,1,hourly,") || !strings.HasSuffix(lines[100], ",99") {
+		t.Errorf("unexpected last record of channel 1 %q", lines[100])
+	}
+	if !strings.HasPrefix(lines[110], "00000001,3,hourly,") || !strings.HasSuffix(lines[111], ",") || !strings.HasSuffix(lines[110], ",9") {
+		t.Errorf("unexpected records of channel 3 %q %q", lines[110], lines[111])
+	}
+	if n := d.Requests(simulator.ReadArchive); n != 4 {
+		t.Errorf("unexpected number of requests %d", n)
+	}
+}
+
+func TestWriteArchivePartial(t *testing.T) {
+	s := simulator.New()
+	d := s.AddDevice(1)
+	d.SetLocation(time.Local)
+	d.SetDayLightSaving(true)
+	d.SetMaxPeriod(pulsar.Hourly, 29)
+	start := time.Date(2022, 1, 10, 0, 0, 0, 0, time.Local)
+	values := make([]float32, 58)
+	for i := range values {
+		values[i] = float32(i)
+	}
+	d.SetArchive(pulsar.Hourly, 1, start, values)
+	// the first request is too long, the second one succeeds and the third one fails.
+	d.InjectFaults(simulator.ReadArchive, simulator.Fault{Kind: simulator.ErrorReply, Code: pulsar.MissingParam, Skip: 2, Count: 1})
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Skipf("tcp is not available: %v", err)
+	}
+	dl := pulsar.Dialer{RWTimeOut: time.Second}
+	conn, err := dl.DialTCP(s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+		_ = s.Close()
+	}()
+	c, _ := pulsar.NewClient("00000001", conn)
+
+	var b bytes.Buffer
+	if err := WriteArchive(context.Background(), c, NewCSVWriter(&b), pulsar.Hourly, start, start.Add(57*time.Hour), 1); err == nil {
+		t.Fatal("error isn't returned")
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 30 || !strings.HasSuffix(lines[29], ",28") {
+		t.Errorf("unexpected records %d %q", len(lines), lines[len(lines)-1])
+	}
+}
@@ -0,0 +1,111 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+)
+
+// DefaultMeasurement is a measurement name used if InfluxOptions.Measurement isn't set.
+const DefaultMeasurement = "pulsar"
+
+// InfluxOptions configures an InfluxWriter.
+type InfluxOptions struct {
+	// Measurement name. Defaults to DefaultMeasurement.
+	Measurement string
+	// Timestamp precision: time.Second, time.Millisecond, time.Microsecond or time.Nanosecond (default).
+	Precision time.Duration
+	// Tags added to every point.
+	Tags map[string]string
+}
+
+// InfluxWriter writes points in InfluxDB line protocol, e.g.
+//
+//	pulsar,address=00112233,channel=1,type=hourly value=12.5 1656630000000000000
+//
+// Missing archive records and values that aren't finite numbers are skipped.
+type InfluxWriter struct {
+	w         *bufio.Writer
+	precision time.Duration
+	// measurement and extra tags prefix of every line.
+	prefix string
+}
+
+// NewInfluxWriter creates a line protocol writer.
+func NewInfluxWriter(w io.Writer, opts InfluxOptions) (*InfluxWriter, error) {
+	if opts.Measurement == "" {
+		opts.Measurement = DefaultMeasurement
+	}
+	switch opts.Precision {
+	case 0:
+		opts.Precision = time.Nanosecond
+	case time.Second, time.Millisecond, time.Microsecond, time.Nanosecond:
+	default:
+		return nil, fmt.Errorf("unsupported precision %v", opts.Precision)
+	}
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(opts.Measurement))
+	keys := make([]string, 0, len(opts.Tags))
+	for k := range opts.Tags {
+		switch k {
+		case "address", "channel", "type":
+			return nil, fmt.Errorf("tag %q is reserved", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, ",%s=%s", tagEscaper.Replace(k), tagEscaper.Replace(opts.Tags[k]))
+	}
+	return &InfluxWriter{w: bufio.NewWriter(w), precision: opts.Precision, prefix: b.String()}, nil
+}
+
+// WriteLog writes archive records of the channel log.
+func (w *InfluxWriter) WriteLog(address uint32, l *pulsar.ChannelLog) error {
+	for i, v := range l.Values {
+		if pulsar.IsNoData(v) {
+			continue
+		}
+		if err := w.point(address, l.Id, l.Type.String(), l.Time(i), float64(v), 32); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteValues writes current values read at t.
+func (w *InfluxWriter) WriteValues(address uint32, t time.Time, values []pulsar.Channel) error {
+	for _, v := range values {
+		if err := w.point(address, v.Id, Current, t, v.Value, 64); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes buffered lines to the underlying writer.
+func (w *InfluxWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// point writes a single line, v is formatted with bits precision.
+func (w *InfluxWriter) point(address uint32, ch uint, typ string, t time.Time, v float64, bits int) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	_, err := fmt.Fprintf(w.w, "%s,address=%08x,channel=%d,type=%s value=%s %d\n",
+		w.prefix, address, ch, typ, strconv.FormatFloat(v, 'f', -1, bits), t.UnixNano()/int64(w.precision))
+	return err
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
@@ -0,0 +1,251 @@
+// Package exporter exposes Pulsar devices readings, health and protocol statistics as Prometheus metrics.
+//
+// Devices are polled in background and scrapes are served from the cached results,
+// so scrapes never cause requests to devices.
+//
+//	e := exporter.New(exporter.Options{Interval: time.Minute})
+//	bus := pulsar.NewBus(conn, pulsar.WithObserver(e.ObserveRequest))
+//	c, _ := bus.Client("00112233")
+//	e.Add(c, 1, 2, 3)
+//	go e.Run(ctx)
+//	http.Handle("/metrics", e)
+package exporter
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+)
+
+// DefaultInterval is a polling interval used if Options.Interval isn't set.
+const DefaultInterval = time.Minute
+
+// DefaultBuckets are request duration histogram buckets in seconds used if Options.Buckets isn't set.
+var DefaultBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// Options configures an Exporter.
+type Options struct {
+	// Polling interval. Defaults to DefaultInterval.
+	Interval time.Duration
+	// Request duration histogram buckets in seconds. Defaults to DefaultBuckets.
+	Buckets []float64
+	// Logger for polling errors. Nothing is logged if nil.
+	Logger *log.Logger
+}
+
+// Exporter polls devices and serves their metrics in Prometheus text format. Exporter is safe for concurrent use.
+type Exporter struct {
+	opts Options
+
+	mu sync.Mutex
+	// polled devices in order of addition.
+	devices []*device
+	// request statistics by device address and function name.
+	requests map[requestKey]*requestStats
+}
+
+// device is a polled device and its cached state.
+type device struct {
+	client   *pulsar.Client
+	channels []uint
+
+	// fields below are guarded by Exporter.mu.
+	// whether the last poll succeeded.
+	up bool
+	// time of the last poll.
+	polled time.Time
+	values []pulsar.Channel
+	// pulse weights by channel.
+	weights     []pulsar.PulseWeight
+	firmware    uint16
+	diagnostics pulsar.Diagnostics
+	// clock drift, valid if drifted is true.
+	drift   time.Duration
+	drifted bool
+}
+
+type requestKey struct {
+	address  uint32
+	function string
+}
+
+// requestStats holds protocol statistics of a device function.
+type requestStats struct {
+	requests uint64
+	errors   uint64
+	crc      uint64
+	timeouts uint64
+	// protocol errors by error code.
+	codes map[pulsar.ErrorCode]uint64
+	// cumulative counts of request durations within buckets.
+	buckets []uint64
+	sum     float64
+}
+
+// New creates an exporter.
+func New(opts Options) *Exporter {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if len(opts.Buckets) == 0 {
+		opts.Buckets = DefaultBuckets
+	}
+	return &Exporter{
+		opts:     opts,
+		requests: make(map[requestKey]*requestStats),
+	}
+}
+
+// Add adds a device to poll. Values and pulse weights of chs channels are exported.
+func (e *Exporter) Add(c *pulsar.Client, chs ...uint) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.devices = append(e.devices, &device{client: c, channels: append([]uint(nil), chs...)})
+}
+
+// Run polls devices every interval with pulsar.Poller until ctx is done. Returns ctx error.
+// Devices must be added before Run. Devices sharing a connection are polled one by one,
+// so they don't compete for it.
+func (e *Exporter) Run(ctx context.Context) error {
+	e.mu.Lock()
+	targets := make([]pulsar.PollTarget, 0, len(e.devices))
+	for _, d := range e.devices {
+		targets = append(targets, pulsar.PollTarget{Client: d.client, Channels: d.channels, Interval: e.opts.Interval})
+	}
+	e.mu.Unlock()
+	if len(targets) == 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	p, err := pulsar.NewPoller(targets, pulsar.WithPollHandler(e.poll))
+	if err != nil {
+		return err
+	}
+	return p.Run(ctx)
+}
+
+// poll reads the rest of device state after its current values are polled and caches it.
+// Cached values are kept if reading fails, so metrics go stale along with pulsar_up.
+func (e *Exporter) poll(ctx context.Context, c *pulsar.Client, rs []pulsar.Reading) {
+	var errs []error
+	var values []pulsar.Channel
+	for _, r := range rs {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+			break
+		}
+		values = append(values, pulsar.Channel{Id: r.Channel, Value: r.Value})
+	}
+	d := e.device(c)
+	var weights []pulsar.PulseWeight
+	var err error
+	if len(d.channels) > 0 {
+		if weights, err = c.PulseWeightContext(ctx, d.channels...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	firmware, err := c.FirmwareVersionContext(ctx)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	health, err := c.HealthContext(ctx, pulsar.HealthOptions{})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	for _, err := range errs {
+		e.logf("%08x: poll failed: %v", c.Address(), err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	d.polled = time.Now()
+	d.up = len(errs) == 0
+	if values != nil {
+		d.values = values
+	}
+	if weights != nil {
+		d.weights = weights
+	}
+	if firmware != 0 {
+		d.firmware = firmware
+	}
+	if health != nil {
+		for f, err := range health.Errors {
+			d.up = false
+			e.logf("%08x: %s check failed: %v", c.Address(), f, err)
+		}
+		if _, ok := health.Errors[pulsar.FieldDiagnostics]; !ok {
+			d.diagnostics = health.Diagnostics
+		}
+		if _, ok := health.Errors[pulsar.FieldTime]; !ok {
+			d.drift, d.drifted = health.ClockDrift, true
+		}
+	}
+}
+
+// device returns the polled device of the client.
+func (e *Exporter) device(c *pulsar.Client) *device {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, d := range e.devices {
+		if d.client == c {
+			return d
+		}
+	}
+	return nil
+}
+
+// ObserveRequest collects protocol statistics, it is a pulsar.RequestObserver to pass to pulsar.WithObserver.
+func (e *Exporter) ObserveRequest(address uint32, function string, elapsed time.Duration, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	k := requestKey{address: address, function: function}
+	s, ok := e.requests[k]
+	if !ok {
+		s = &requestStats{codes: make(map[pulsar.ErrorCode]uint64), buckets: make([]uint64, len(e.opts.Buckets))}
+		e.requests[k] = s
+	}
+	s.requests++
+	v := elapsed.Seconds()
+	s.sum += v
+	for i, b := range e.opts.Buckets {
+		if v <= b {
+			s.buckets[i]++
+		}
+	}
+	if err == nil {
+		return
+	}
+	s.errors++
+	var pe *pulsar.ProtocolError
+	var ne net.Error
+	switch {
+	case errors.Is(err, pulsar.ErrCRC):
+		s.crc++
+	case errors.As(err, &pe):
+		s.codes[pe.Code()]++
+	case errors.As(err, &ne) && ne.Timeout():
+		s.timeouts++
+	}
+}
+
+// ServeHTTP serves metrics in Prometheus text format.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	_ = e.Write(w)
+}
+
+func (e *Exporter) logf(format string, v ...interface{}) {
+	if e.opts.Logger != nil {
+		e.opts.Logger.Printf(format, v...)
+	}
+}
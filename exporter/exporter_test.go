@@ -0,0 +1,128 @@
+package exporter
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+	"github.com/srgsf/tvh-pulsar/simulator"
+)
+
+func TestExporter(t *testing.T) {
+	s := simulator.New()
+	d := s.AddDevice(0x00112233)
+	d.SetValue(2, 12.5)
+	d.SetDiagnostics(uint64(pulsar.DiagNegativeValue))
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Skipf("tcp is not available: %v", err)
+	}
+	dl := pulsar.Dialer{RWTimeOut: 50 * time.Millisecond}
+	conn, err := dl.DialTCP(s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+		_ = s.Close()
+	}()
+
+	e := New(Options{Interval: time.Hour})
+	b := pulsar.NewBus(conn, pulsar.WithObserver(e.ObserveRequest))
+	c1, _ := b.Client("00112233")
+	c2, _ := b.Client("00000001")
+	e.Add(c1, 1, 2)
+	e.Add(c2, 1)
+	d.InjectFaults(simulator.ReadArchive, simulator.Fault{Kind: simulator.ErrorReply, Code: pulsar.MissingArchive, Count: 1})
+	if _, err := c1.HourlyLog(1, time.Now(), time.Now()); err == nil {
+		t.Error("error isn't returned")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- e.Run(ctx) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for e.polled() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	out := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE pulsar_up gauge",
+		`pulsar_up{address="00112233"} 1`,
+		`pulsar_up{address="00000001"} 0`,
+		`pulsar_channel_value{address="00112233",channel="2"} 12.5`,
+		`pulsar_channel_pulse_weight{address="00112233",channel="1"} 0.009999999776482582`,
+		`pulsar_firmware_version{address="00112233"} 102`,
+		`pulsar_diagnostics_problem{address="00112233",flag="negative_value"} 1`,
+		`pulsar_diagnostics_problem{address="00112233",flag="eeprom_write"} 0`,
+		`pulsar_requests_total{address="00112233",function="ReadValues"} 1`,
+		`pulsar_protocol_errors_total{address="00112233",function="ReadArchive",code="7"} 1`,
+		`pulsar_timeouts_total{address="00000001",function="ReadValues"} 1`,
+		`pulsar_request_duration_seconds_bucket{address="00112233",function="ReadValues",le="+Inf"} 1`,
+		`pulsar_request_duration_seconds_count{address="00112233",function="ReadSysTime"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("%q is missing in:\n%s", line, out)
+		}
+	}
+	if !strings.Contains(out, `pulsar_clock_drift_seconds{address="00112233"} `) {
+		t.Errorf("clock drift is missing in:\n%s", out)
+	}
+
+	// cached values are kept when the device stops responding.
+	d.InjectFaults(simulator.AnyFunction, simulator.Fault{Kind: simulator.Drop})
+	polled := e.lastPoll(c1)
+	deadline = time.Now().Add(5 * time.Second)
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { done <- e.Run(ctx) }()
+	for e.lastPoll(c1).Equal(polled) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out = rec.Body.String()
+	for _, line := range []string{
+		`pulsar_up{address="00112233"} 0`,
+		`pulsar_channel_value{address="00112233",channel="2"} 12.5`,
+		`pulsar_channel_pulse_weight{address="00112233",channel="1"} 0.009999999776482582`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("%q is missing in:\n%s", line, out)
+		}
+	}
+}
+
+// lastPoll returns the time of the last poll of the client device.
+func (e *Exporter) lastPoll(c *pulsar.Client) time.Time {
+	d := e.device(c)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return d.polled
+}
+
+// polled returns the number of polled devices.
+func (e *Exporter) polled() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var n int
+	for _, d := range e.devices {
+		if !d.polled.IsZero() {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,197 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+)
+
+// contentType is Prometheus text exposition format content type.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// diagnostics flags exported as separate series.
+var diagnosticsFlags = []struct {
+	flag pulsar.Diagnostics
+	name string
+}{
+	{pulsar.DiagEEPROMWrite, "eeprom_write"},
+	{pulsar.DiagNegativeValue, "negative_value"},
+}
+
+// Write writes metrics in Prometheus text format.
+func (e *Exporter) Write(w io.Writer) error {
+	var b bytes.Buffer
+	e.mu.Lock()
+	e.writeDevices(&b)
+	e.writeRequests(&b)
+	e.mu.Unlock()
+	_, err := b.WriteTo(w)
+	return err
+}
+
+// writeDevices writes cached devices state. Must be called with mu held.
+func (e *Exporter) writeDevices(b *bytes.Buffer) {
+	devices := append([]*device(nil), e.devices...)
+	sort.SliceStable(devices, func(i, j int) bool { return devices[i].client.Address() < devices[j].client.Address() })
+
+	header(b, "pulsar_up", "gauge", "Whether the last poll of the device succeeded.")
+	for _, d := range devices {
+		sample(b, "pulsar_up", boolValue(d.up), "address", address(d))
+	}
+	header(b, "pulsar_last_poll_timestamp_seconds", "gauge", "Time of the last poll of the device.")
+	for _, d := range devices {
+		if !d.polled.IsZero() {
+			sample(b, "pulsar_last_poll_timestamp_seconds", float64(d.polled.UnixNano())/1e9, "address", address(d))
+		}
+	}
+	header(b, "pulsar_channel_value", "gauge", "Current counter value of the channel.")
+	for _, d := range devices {
+		for _, v := range d.values {
+			sample(b, "pulsar_channel_value", v.Value, "address", address(d), "channel", strconv.Itoa(int(v.Id)))
+		}
+	}
+	header(b, "pulsar_channel_pulse_weight", "gauge", "Pulse weight of the channel.")
+	for _, d := range devices {
+		for _, w := range d.weights {
+			sample(b, "pulsar_channel_pulse_weight", float64(w.Value), "address", address(d), "channel", strconv.Itoa(int(w.Id)))
+		}
+	}
+	header(b, "pulsar_firmware_version", "gauge", "Firmware version of the device.")
+	for _, d := range devices {
+		if d.firmware != 0 {
+			sample(b, "pulsar_firmware_version", float64(d.firmware), "address", address(d))
+		}
+	}
+	header(b, "pulsar_diagnostics_flags", "gauge", "Raw self-check flags of the device.")
+	for _, d := range devices {
+		if !d.polled.IsZero() {
+			sample(b, "pulsar_diagnostics_flags", float64(d.diagnostics), "address", address(d))
+		}
+	}
+	header(b, "pulsar_diagnostics_problem", "gauge", "Whether the device self-check reports the problem.")
+	for _, d := range devices {
+		if d.polled.IsZero() {
+			continue
+		}
+		for _, f := range diagnosticsFlags {
+			sample(b, "pulsar_diagnostics_problem", boolValue(d.diagnostics.Has(f.flag)), "address", address(d), "flag", f.name)
+		}
+	}
+	header(b, "pulsar_clock_drift_seconds", "gauge", "Device clock minus local clock.")
+	for _, d := range devices {
+		if d.drifted {
+			sample(b, "pulsar_clock_drift_seconds", d.drift.Seconds(), "address", address(d))
+		}
+	}
+}
+
+// writeRequests writes protocol statistics. Must be called with mu held.
+func (e *Exporter) writeRequests(b *bytes.Buffer) {
+	keys := make([]requestKey, 0, len(e.requests))
+	for k := range e.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].address != keys[j].address {
+			return keys[i].address < keys[j].address
+		}
+		return keys[i].function < keys[j].function
+	})
+	counters := []struct {
+		name, help string
+		value      func(s *requestStats) uint64
+	}{
+		{"pulsar_requests_total", "Number of request attempts.", func(s *requestStats) uint64 { return s.requests }},
+		{"pulsar_request_errors_total", "Number of failed request attempts.", func(s *requestStats) uint64 { return s.errors }},
+		{"pulsar_crc_errors_total", "Number of replies with invalid checksum.", func(s *requestStats) uint64 { return s.crc }},
+		{"pulsar_timeouts_total", "Number of request attempts failed due to i/o timeout.", func(s *requestStats) uint64 { return s.timeouts }},
+	}
+	for _, c := range counters {
+		header(b, c.name, "counter", c.help)
+		for _, k := range keys {
+			sample(b, c.name, float64(c.value(e.requests[k])), "address", fmt.Sprintf("%08x", k.address), "function", k.function)
+		}
+	}
+
+	header(b, "pulsar_protocol_errors_total", "counter", "Number of error replies by error code.")
+	for _, k := range keys {
+		s := e.requests[k]
+		codes := make([]pulsar.ErrorCode, 0, len(s.codes))
+		for c := range s.codes {
+			codes = append(codes, c)
+		}
+		sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+		for _, c := range codes {
+			sample(b, "pulsar_protocol_errors_total", float64(s.codes[c]),
+				"address", fmt.Sprintf("%08x", k.address), "function", k.function, "code", strconv.Itoa(int(c)))
+		}
+	}
+
+	const hist = "pulsar_request_duration_seconds"
+	header(b, hist, "histogram", "Duration of request attempts.")
+	for _, k := range keys {
+		s := e.requests[k]
+		addr := fmt.Sprintf("%08x", k.address)
+		for i, le := range e.opts.Buckets {
+			sample(b, hist+"_bucket", float64(s.buckets[i]), "address", addr, "function", k.function, "le", formatValue(le))
+		}
+		sample(b, hist+"_bucket", float64(s.requests), "address", addr, "function", k.function, "le", "+Inf")
+		sample(b, hist+"_sum", s.sum, "address", addr, "function", k.function)
+		sample(b, hist+"_count", float64(s.requests), "address", addr, "function", k.function)
+	}
+}
+
+// header writes metric family HELP and TYPE lines.
+func header(b *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a sample line, labels are name and value pairs.
+func sample(b *bytes.Buffer, name string, v float64, labels ...string) {
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatValue(v))
+	b.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func boolValue(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func address(d *device) string {
+	return fmt.Sprintf("%08x", d.client.Address())
+}
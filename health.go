@@ -0,0 +1,105 @@
+package pulsar
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultMaxDrift is a clock drift reported as a problem if HealthOptions.MaxDrift isn't set.
+const DefaultMaxDrift = time.Minute
+
+// name of input test check in HealthReport.Errors.
+const checkInputs = "Inputs"
+
+// HealthOptions configures device health check.
+type HealthOptions struct {
+	// Channels to run input test for. Input test is skipped if empty.
+	Channels []uint
+	// Maximum acceptable clock drift. Defaults to DefaultMaxDrift.
+	MaxDrift time.Duration
+}
+
+// HealthReport combines device self-check, clock drift and sensor inputs state.
+type HealthReport struct {
+	// Device address.
+	Address uint32
+	// Time of the check.
+	Checked time.Time
+	// Self-check results.
+	Diagnostics Diagnostics
+	// Device clock.
+	DeviceTime time.Time
+	// Device clock minus local clock compensated by a half of request round trip time.
+	// Device clock resolution is a second.
+	ClockDrift time.Duration
+	// Maximum acceptable clock drift.
+	MaxDrift time.Duration
+	// Input states of HealthOptions.Channels.
+	Inputs SensorTest
+	// Failed checks keyed by FieldDiagnostics, FieldTime or "Inputs".
+	Errors map[string]error
+}
+
+// Healthy reports whether all checks succeeded and found no problems.
+func (r *HealthReport) Healthy() bool {
+	return len(r.Problems()) == 0
+}
+
+// Problems returns descriptions of found problems and failed checks.
+func (r *HealthReport) Problems() []string {
+	var rv []string
+	checks := make([]string, 0, len(r.Errors))
+	for c := range r.Errors {
+		checks = append(checks, c)
+	}
+	sort.Strings(checks)
+	for _, c := range checks {
+		rv = append(rv, fmt.Sprintf("%s check failed: %v", c, r.Errors[c]))
+	}
+	rv = append(rv, r.Diagnostics.Problems()...)
+	if _, ok := r.Errors[FieldTime]; !ok && (r.ClockDrift > r.MaxDrift || r.ClockDrift < -r.MaxDrift) {
+		rv = append(rv, fmt.Sprintf("clock drift %v exceeds %v", r.ClockDrift, r.MaxDrift))
+	}
+	return rv
+}
+
+// Health runs device health check.
+// Failed checks are reported in HealthReport.Errors, error is returned only if ctx is done.
+func (c *Client) Health(opts HealthOptions) (*HealthReport, error) {
+	return c.HealthContext(context.Background(), opts)
+}
+
+// HealthContext is like Health but aborts the request when ctx is done.
+func (c *Client) HealthContext(ctx context.Context, opts HealthOptions) (*HealthReport, error) {
+	r := &HealthReport{
+		Address:  c.address,
+		Checked:  time.Now(),
+		MaxDrift: opts.MaxDrift,
+		Errors:   make(map[string]error),
+	}
+	if r.MaxDrift <= 0 {
+		r.MaxDrift = DefaultMaxDrift
+	}
+
+	var err error
+	if r.Diagnostics, err = c.DiagnosticsFlagsContext(ctx); err != nil {
+		r.Errors[FieldDiagnostics] = err
+	}
+	var local time.Time
+	if r.DeviceTime, local, _, err = c.readClock(ctx); err != nil {
+		r.Errors[FieldTime] = err
+	} else {
+		r.ClockDrift = r.DeviceTime.Sub(local)
+	}
+	if len(opts.Channels) > 0 {
+		if r.Inputs, err = c.CheckInputsContext(ctx, opts.Channels...); err != nil {
+			r.Errors[checkInputs] = err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
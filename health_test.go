@@ -0,0 +1,54 @@
+package pulsar_test
+
+import (
+	"testing"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+	"github.com/srgsf/tvh-pulsar/simulator"
+)
+
+func TestHealth(t *testing.T) {
+	d, cl := startDevice(t, 1)
+	d.SetLocation(time.Local)
+	d.SetDayLightSaving(true)
+
+	r, err := cl.Health(pulsar.HealthOptions{Channels: []uint{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Healthy() {
+		t.Errorf("unexpected problems %v", r.Problems())
+	}
+	if r.ClockDrift > 2*time.Second || r.ClockDrift < -2*time.Second {
+		t.Errorf("unexpected clock drift %v", r.ClockDrift)
+	}
+
+	d.Drift(5 * time.Minute)
+	d.SetDiagnostics(uint64(pulsar.DiagEEPROMWrite))
+	d.SetInputs(0x02)
+	d.InjectFaults(simulator.InputTest, simulator.Fault{Kind: simulator.ErrorReply, Code: pulsar.InvalidBitMask, Count: 1})
+	r, err = cl.Health(pulsar.HealthOptions{Channels: []uint{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Healthy() {
+		t.Error("problems aren't found")
+	}
+	if !r.Diagnostics.EEPROMWriteError() {
+		t.Errorf("unexpected diagnostics %v", r.Diagnostics)
+	}
+	if r.ClockDrift < 4*time.Minute {
+		t.Errorf("unexpected clock drift %v", r.ClockDrift)
+	}
+	if len(r.Problems()) != 3 {
+		t.Errorf("unexpected problems %v", r.Problems())
+	}
+
+	if r, err = cl.Health(pulsar.HealthOptions{Channels: []uint{1, 2}, MaxDrift: 10 * time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	if r.Inputs.State(1) != pulsar.SensorShorted || r.Inputs.State(2) != pulsar.SensorOpen || len(r.Problems()) != 1 {
+		t.Errorf("unexpected report %+v: %v", r, r.Problems())
+	}
+}
@@ -3,6 +3,7 @@ package pulsar
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -35,16 +36,36 @@ type Conn interface {
 	Close() error
 }
 
+// ContextConn is an optional interface implemented by connections that are able to bound
+// frame exchanges by a context.
+type ContextConn interface {
+	Conn
+	// Bind makes subsequent PrepareRead and PrepareWrite calls honor ctx deadline
+	// and aborts pending i/o operations as soon as ctx is done.
+	// Returned function releases the binding and must be called once the exchange is over.
+	Bind(ctx context.Context) (release func())
+}
+
+// stream is a byte stream that supports i/o deadlines, e.g. net.Conn or *os.File.
+type stream interface {
+	io.ReadWriteCloser
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
 // tcpConn is a network connection handle
 type tcpConn struct {
 	// wrapped connection
-	rwc net.Conn
+	rwc stream
 	// i/o operations timeout
 	to time.Duration
 	// buffered reader handler.
 	r reader
 	//buffered writer handler
 	w writer
+	// context of current frame exchange.
+	ctx context.Context
 }
 
 // Close closes the connection.
@@ -55,7 +76,11 @@ func (c *tcpConn) Close() error {
 // prepareRead configures frame reading operation. Call it once before frame sequential reads.
 func (c *tcpConn) PrepareRead() error {
 	c.r.reset(c.rwc)
-	if err := c.rwc.SetReadDeadline(time.Now().Add(c.to)); err != nil {
+	dl, err := c.deadline()
+	if err != nil {
+		return err
+	}
+	if err := c.rwc.SetReadDeadline(dl); err != nil {
 		return err
 	}
 	return nil
@@ -64,12 +89,56 @@ func (c *tcpConn) PrepareRead() error {
 // prepareWrite configures frame writing operation. Call it once before frame sequential writes.
 func (c *tcpConn) PrepareWrite() error {
 	c.w.reset(c.rwc)
-	if err := c.rwc.SetWriteDeadline(time.Now().Add(c.to)); err != nil {
+	dl, err := c.deadline()
+	if err != nil {
+		return err
+	}
+	if err := c.rwc.SetWriteDeadline(dl); err != nil {
 		return err
 	}
 	return nil
 }
 
+// deadline calculates i/o deadline for the next frame operation.
+// Bound context deadline is used if it expires earlier than i/o operation timeout.
+func (c *tcpConn) deadline() (time.Time, error) {
+	dl := time.Now().Add(c.to)
+	if c.ctx == nil {
+		return dl, nil
+	}
+	if err := c.ctx.Err(); err != nil {
+		return dl, err
+	}
+	if cdl, ok := c.ctx.Deadline(); ok && cdl.Before(dl) {
+		dl = cdl
+	}
+	return dl, nil
+}
+
+// Bind makes frame operations honor ctx deadline and cancellation.
+func (c *tcpConn) Bind(ctx context.Context) func() {
+	c.ctx = ctx
+	if ctx.Done() == nil {
+		return func() { c.ctx = nil }
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			// unblocks pending reads and writes.
+			_ = c.rwc.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		c.ctx = nil
+	}
+}
+
 // write the contents of p into device.
 // It returns the number of bytes written from p (0 <= n <= len(p))
 // and any error encountered that caused the write to stop early.
@@ -98,7 +167,7 @@ func (c *tcpConn) LogRequest() {
 	c.w.Log("request")
 }
 
-// A Dialer contains options for connecting to a network.
+// A Dialer contains options for connecting to a network or a serial port.
 type Dialer struct {
 	// Tcp socket connection timeout.
 	ConnectionTimeOut time.Duration
@@ -106,6 +175,11 @@ type Dialer struct {
 	RWTimeOut time.Duration
 	// Logger for received and sent frames.
 	ProtocolLogger *log.Logger
+	// Minimal serial line silence interval between frames.
+	// Defaults to 3.5 characters transmission time at the port speed, the usual rs485 inter-frame gap.
+	FrameSilence time.Duration
+	// Redial makes connection re-establish itself on the next frame operation after i/o failure.
+	Redial bool
 }
 
 // DialTCP connects to the tcp socket on the named network.
@@ -118,6 +192,16 @@ func DialTCP(socket string) (Conn, error) {
 // DialTCP connects to the tcp socket on the named network.
 // The socket has the form "host:port".
 func (d *Dialer) DialTCP(socket string) (Conn, error) {
+	if d.Redial {
+		return newRedialConn(func() (Conn, error) {
+			return d.dialTCP(socket)
+		})
+	}
+	return d.dialTCP(socket)
+}
+
+// dialTCP establishes tcp connection.
+func (d *Dialer) dialTCP(socket string) (Conn, error) {
 	conn, err := net.DialTimeout("tcp", socket, d.ConnectionTimeOut)
 	if err != nil {
 		return nil, err
@@ -131,7 +215,7 @@ func (d *Dialer) DialTCP(socket string) (Conn, error) {
 }
 
 // creates connection.
-func newConn(conn net.Conn, log *log.Logger, to time.Duration) *tcpConn {
+func newConn(conn stream, log *log.Logger, to time.Duration) *tcpConn {
 	var l = &logger{
 		log: log,
 	}
@@ -146,6 +230,7 @@ func newConn(conn net.Conn, log *log.Logger, to time.Duration) *tcpConn {
 			l,
 			bufio.NewWriter(conn),
 		},
+		nil,
 	}
 }
 
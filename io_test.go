@@ -2,6 +2,7 @@ package pulsar
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"log"
 	"net"
@@ -182,3 +183,33 @@ func TestLoggerWriteReset(t *testing.T) {
 		t.Error("frame isn't reset")
 	}
 }
+
+func TestBoundReadDeadline(t *testing.T) {
+	var c mockConn
+	conn := newConn(&c, nil, time.Hour)
+	dl := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), dl)
+	defer cancel()
+	release := conn.Bind(ctx)
+	_ = conn.PrepareRead()
+	release()
+	if !c.readDeadLine.Equal(dl) {
+		t.Error("context deadline is not applied to frame read")
+	}
+	_ = conn.PrepareRead()
+	if c.readDeadLine.Equal(dl) {
+		t.Error("context deadline is applied after release")
+	}
+}
+
+func TestBoundCanceled(t *testing.T) {
+	var c mockConn
+	conn := newConn(&c, nil, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	release := conn.Bind(ctx)
+	defer release()
+	if err := conn.PrepareWrite(); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
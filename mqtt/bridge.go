@@ -0,0 +1,395 @@
+// Package mqtt publishes Pulsar devices readings, archive records and diagnostics to MQTT topics
+// and executes commands received on command topics.
+//
+// Topics of a device with address 00112233 and the default prefix:
+//
+//	pulsar/00112233/status                  online or offline, retained
+//	pulsar/00112233/channel/1/value         {"value":12.5,"time":"..."}, retained
+//	pulsar/00112233/archive/hourly/1        {"value":12.5,"time":"..."} of the last complete period, retained
+//	pulsar/00112233/diagnostics             {"flags":0,"problems":[],"clock_drift":0.5,"time":"..."}, retained
+//	pulsar/00112233/channel/1/set           command: sets counter value to the payload number
+//	pulsar/00112233/sync_time               command: corrects device clock, see pulsar.Client.SyncTime
+//	pulsar/00112233/result                  {"command":"...","error":"..."} of executed commands
+//
+// Home Assistant discovery payloads are published if Options.DiscoveryPrefix is set.
+//
+// The package has no MQTT transport: callers supply a Broker adapter wrapping an MQTT client library
+// of their choice, MemoryBroker is an in-process implementation for tests.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+)
+
+// DefaultPrefix is a topic prefix used if Options.Prefix isn't set.
+const DefaultPrefix = "pulsar"
+
+// DefaultInterval is a polling interval used if Options.Interval isn't set.
+const DefaultInterval = time.Minute
+
+// Device is a device published by Bridge.
+type Device struct {
+	// Device client.
+	Client *pulsar.Client
+	// Channels to publish.
+	Channels []uint
+	// Device name used in discovery payloads. Defaults to "Pulsar <address>".
+	Name string
+}
+
+// Options configures a Bridge.
+type Options struct {
+	// Topic prefix. Defaults to DefaultPrefix.
+	Prefix string
+	// Home Assistant discovery prefix, e.g. "homeassistant". Discovery payloads aren't published if empty.
+	DiscoveryPrefix string
+	// QoS of published messages and command subscriptions.
+	QoS byte
+	// Polling interval. Defaults to DefaultInterval.
+	Interval time.Duration
+	// Archives to publish the last complete records of.
+	Archives []pulsar.ArchType
+	// Options of sync_time command.
+	Sync pulsar.SyncOptions
+	// Logger for polling and command errors. Nothing is logged if nil.
+	Logger *log.Logger
+}
+
+// Bridge polls devices and publishes their state to MQTT broker.
+type Bridge struct {
+	broker  Broker
+	opts    Options
+	devices []*device
+	// received commands.
+	commands chan command
+}
+
+// device is a published device and its publishing state.
+type device struct {
+	Device
+	// topics prefix of the device.
+	topic string
+	// start of the last published archive period by archive type and channel.
+	archived map[archiveKey]time.Time
+	// whether device responded to the last poll.
+	online bool
+	// whether the status was published.
+	announced bool
+}
+
+// archiveKey is an archive of a channel.
+type archiveKey struct {
+	typ pulsar.ArchType
+	ch  uint
+}
+
+// command is a command received on a command topic.
+type command struct {
+	device *device
+	// command name.
+	name string
+	// channel of set command.
+	channel uint
+	payload []byte
+}
+
+// reading is a published value.
+type reading struct {
+	Value float64   `json:"value"`
+	Time  time.Time `json:"time"`
+}
+
+// diagnostics is a published device diagnostics.
+type diagnostics struct {
+	Flags      uint8     `json:"flags"`
+	Problems   []string  `json:"problems"`
+	ClockDrift *float64  `json:"clock_drift,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// result is a published command result.
+type result struct {
+	Command string `json:"command"`
+	Error   string `json:"error,omitempty"`
+}
+
+// NewBridge creates a bridge of devices to the broker.
+func NewBridge(broker Broker, devices []Device, opts Options) (*Bridge, error) {
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultPrefix
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.QoS > 2 {
+		return nil, fmt.Errorf("invalid qos %d", opts.QoS)
+	}
+	for _, typ := range opts.Archives {
+		if typ < pulsar.Hourly || typ > pulsar.Monthly {
+			return nil, fmt.Errorf("unknown archive type %d", typ)
+		}
+	}
+	b := &Bridge{broker: broker, opts: opts, commands: make(chan command, 16)}
+	for i, d := range devices {
+		if d.Client == nil {
+			return nil, fmt.Errorf("device %d: client is required", i)
+		}
+		addr := fmt.Sprintf("%08x", d.Client.Address())
+		if d.Name == "" {
+			d.Name = "Pulsar " + addr
+		}
+		b.devices = append(b.devices, &device{
+			Device:   d,
+			topic:    opts.Prefix + "/" + addr,
+			archived: make(map[archiveKey]time.Time),
+		})
+	}
+	return b, nil
+}
+
+// Run subscribes to command topics, publishes discovery payloads and then publishes devices state every interval
+// until ctx is done. Devices are polled with pulsar.Poller, commands are executed as they are received.
+// Returns ctx error or the error of subscription.
+func (b *Bridge) Run(ctx context.Context) error {
+	for _, d := range b.devices {
+		d := d
+		if err := b.broker.Subscribe(ctx, d.topic+"/channel/+/set", b.opts.QoS, func(topic string, payload []byte) {
+			ch, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(topic, d.topic+"/channel/"), "/set"), 10, 8)
+			if err != nil || ch == 0 {
+				b.logf("%s: invalid command topic", topic)
+				return
+			}
+			b.enqueue(command{device: d, name: "set", channel: uint(ch), payload: payload})
+		}); err != nil {
+			return err
+		}
+		if err := b.broker.Subscribe(ctx, d.topic+"/sync_time", b.opts.QoS, func(_ string, payload []byte) {
+			b.enqueue(command{device: d, name: "sync_time", payload: payload})
+		}); err != nil {
+			return err
+		}
+		if b.opts.DiscoveryPrefix != "" {
+			if err := b.discover(ctx, d); err != nil {
+				return err
+			}
+		}
+	}
+
+	targets := make([]pulsar.PollTarget, 0, len(b.devices))
+	for _, d := range b.devices {
+		targets = append(targets, pulsar.PollTarget{Client: d.Client, Channels: d.Channels, Interval: b.opts.Interval})
+	}
+	polled := make(chan struct{})
+	if len(targets) > 0 {
+		p, err := pulsar.NewPoller(targets, pulsar.WithPollHandler(b.poll))
+		if err != nil {
+			return err
+		}
+		go func() {
+			_ = p.Run(ctx)
+			close(polled)
+		}()
+	} else {
+		close(polled)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			<-polled
+			b.shutdown()
+			return ctx.Err()
+		case cmd := <-b.commands:
+			b.execute(ctx, cmd)
+		}
+	}
+}
+
+// enqueue passes a command to Run loop. Commands are dropped if the queue is full.
+func (b *Bridge) enqueue(cmd command) {
+	select {
+	case b.commands <- cmd:
+	default:
+		b.logf("%s: command %s is dropped, queue is full", cmd.device.topic, cmd.name)
+	}
+}
+
+// poll publishes polled readings of the device, archive records and diagnostics.
+func (b *Bridge) poll(ctx context.Context, c *pulsar.Client, rs []pulsar.Reading) {
+	d := b.device(c)
+	online := true
+	for _, r := range rs {
+		if r.Err != nil {
+			if online {
+				b.logf("%s: reading values failed: %v", d.topic, r.Err)
+			}
+			online = false
+			continue
+		}
+		if r.Quality == pulsar.QualityGood {
+			b.publish(ctx, fmt.Sprintf("%s/channel/%d/value", d.topic, r.Channel), true, reading{Value: r.Value, Time: r.Time})
+		}
+	}
+	if online {
+		for _, typ := range b.opts.Archives {
+			if err := b.publishArchive(ctx, d, typ); err != nil {
+				b.logf("%s: reading %s archive failed: %v", d.topic, typ, err)
+			}
+		}
+		if err := b.publishDiagnostics(ctx, d); err != nil {
+			b.logf("%s: reading diagnostics failed: %v", d.topic, err)
+			online = false
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	b.setStatus(ctx, d, online)
+}
+
+// device returns the published device of the client.
+func (b *Bridge) device(c *pulsar.Client) *device {
+	for _, d := range b.devices {
+		if d.Client == c {
+			return d
+		}
+	}
+	return nil
+}
+
+// publishArchive publishes the last complete archive records of channels if they aren't published yet.
+// Missing records are requested again on the next poll.
+func (b *Bridge) publishArchive(ctx context.Context, d *device, typ pulsar.ArchType) error {
+	t := time.Now()
+	switch typ {
+	case pulsar.Daily:
+		t = t.AddDate(0, 0, -1)
+	case pulsar.Monthly:
+		t = t.AddDate(0, -1, 0)
+	default:
+		t = t.Add(-time.Hour)
+	}
+	// start of the last complete period, known after the first request.
+	var start time.Time
+	for _, ch := range d.Channels {
+		k := archiveKey{typ: typ, ch: ch}
+		if !start.IsZero() && start.Equal(d.archived[k]) {
+			continue
+		}
+		l, err := d.Client.ArchiveContext(ctx, typ, ch, t, t, pulsar.ArchiveOptions{})
+		if err != nil {
+			return err
+		}
+		start = l.Start
+		if start.Equal(d.archived[k]) || len(l.Values) == 0 || pulsar.IsNoData(l.Values[0]) {
+			continue
+		}
+		topic := fmt.Sprintf("%s/archive/%s/%d", d.topic, typ, ch)
+		b.publish(ctx, topic, true, reading{Value: float64(l.Values[0]), Time: l.Start})
+		d.archived[k] = start
+	}
+	return nil
+}
+
+// publishDiagnostics publishes device self-check results and clock drift.
+func (b *Bridge) publishDiagnostics(ctx context.Context, d *device) error {
+	r, err := d.Client.HealthContext(ctx, pulsar.HealthOptions{})
+	if err != nil {
+		return err
+	}
+	if err, ok := r.Errors[pulsar.FieldDiagnostics]; ok {
+		return err
+	}
+	p := diagnostics{Flags: uint8(r.Diagnostics), Problems: r.Diagnostics.Problems(), Time: r.Checked}
+	if p.Problems == nil {
+		p.Problems = []string{}
+	}
+	if _, ok := r.Errors[pulsar.FieldTime]; !ok {
+		drift := r.ClockDrift.Seconds()
+		p.ClockDrift = &drift
+	}
+	b.publish(ctx, d.topic+"/diagnostics", true, p)
+	return nil
+}
+
+// setStatus publishes device availability if it is changed.
+func (b *Bridge) setStatus(ctx context.Context, d *device, online bool) {
+	if d.announced && d.online == online {
+		return
+	}
+	status := "offline"
+	if online {
+		status = "online"
+	}
+	if err := b.broker.Publish(ctx, d.topic+"/status", b.opts.QoS, true, []byte(status)); err != nil {
+		b.logf("%s: publishing status failed: %v", d.topic, err)
+		return
+	}
+	d.online, d.announced = online, true
+}
+
+// shutdown marks devices offline.
+func (b *Bridge) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, d := range b.devices {
+		d.announced = false
+		b.setStatus(ctx, d, false)
+	}
+}
+
+// execute runs a command and publishes its result.
+func (b *Bridge) execute(ctx context.Context, cmd command) {
+	d := cmd.device
+	var err error
+	switch cmd.name {
+	case "set":
+		var v float64
+		if v, err = strconv.ParseFloat(strings.TrimSpace(string(cmd.payload)), 64); err != nil {
+			err = fmt.Errorf("invalid value %q", cmd.payload)
+			break
+		}
+		if err = d.Client.SetCurValueContext(ctx, cmd.channel, v); err != nil {
+			break
+		}
+		var values []pulsar.Channel
+		if values, err = d.Client.CurValuesContext(ctx, cmd.channel); err == nil {
+			b.publish(ctx, fmt.Sprintf("%s/channel/%d/value", d.topic, cmd.channel), true, reading{Value: values[0].Value, Time: time.Now()})
+		}
+		cmd.name = fmt.Sprintf("set %d %g", cmd.channel, v)
+	case "sync_time":
+		if _, err = d.Client.SyncTimeContext(ctx, b.opts.Sync); err == nil {
+			err = b.publishDiagnostics(ctx, d)
+		}
+	}
+	r := result{Command: cmd.name}
+	if err != nil {
+		r.Error = err.Error()
+		b.logf("%s: command %s failed: %v", d.topic, cmd.name, err)
+	}
+	b.publish(ctx, d.topic+"/result", false, r)
+}
+
+// publish publishes JSON encoded v.
+func (b *Bridge) publish(ctx context.Context, topic string, retained bool, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err == nil {
+		err = b.broker.Publish(ctx, topic, b.opts.QoS, retained, payload)
+	}
+	if err != nil {
+		b.logf("%s: publishing failed: %v", topic, err)
+	}
+}
+
+func (b *Bridge) logf(format string, v ...interface{}) {
+	if b.opts.Logger != nil {
+		b.opts.Logger.Printf(format, v...)
+	}
+}
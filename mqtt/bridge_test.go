@@ -0,0 +1,168 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+	"github.com/srgsf/tvh-pulsar/simulator"
+)
+
+func TestBridge(t *testing.T) {
+	s := simulator.New()
+	d := s.AddDevice(1)
+	d.SetLocation(time.Local)
+	d.SetDayLightSaving(true)
+	d.SetValue(1, 10.5)
+	d.SetArchive(pulsar.Hourly, 1, time.Now().Add(-time.Hour), []float32{7})
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Skipf("tcp is not available: %v", err)
+	}
+	dl := pulsar.Dialer{RWTimeOut: time.Second}
+	conn, err := dl.DialTCP(s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+		_ = s.Close()
+	}()
+	c, _ := pulsar.NewClient("00000001", conn)
+
+	m := NewMemoryBroker()
+	b, err := NewBridge(m, []Device{{Client: c, Channels: []uint{1, 2}}}, Options{
+		DiscoveryPrefix: "homeassistant",
+		QoS:             1,
+		Interval:        time.Hour,
+		Archives:        []pulsar.ArchType{pulsar.Hourly},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- b.Run(ctx) }()
+	waitRetained(t, m, "pulsar/00000001/status", "online")
+
+	var r struct {
+		Value float64
+		Time  time.Time
+	}
+	retainedJSON(t, m, "pulsar/00000001/channel/1/value", &r)
+	if r.Value != 10.5 || r.Time.IsZero() {
+		t.Errorf("unexpected value %+v", r)
+	}
+	retainedJSON(t, m, "pulsar/00000001/archive/hourly/1", &r)
+	if r.Value != 7 {
+		t.Errorf("unexpected archive record %+v", r)
+	}
+	if _, ok := m.Retained("pulsar/00000001/archive/hourly/2"); ok {
+		t.Error("missing archive record is published")
+	}
+	var diag struct {
+		Flags      uint8
+		Problems   []string
+		ClockDrift *float64 `json:"clock_drift"`
+	}
+	retainedJSON(t, m, "pulsar/00000001/diagnostics", &diag)
+	if diag.Flags != 0 || len(diag.Problems) != 0 || diag.ClockDrift == nil {
+		t.Errorf("unexpected diagnostics %+v", diag)
+	}
+	var cfg struct {
+		StateTopic string `json:"state_topic"`
+		Device     struct {
+			SWVersion string `json:"sw_version"`
+		}
+	}
+	retainedJSON(t, m, "homeassistant/sensor/pulsar_00000001_2/config", &cfg)
+	if cfg.StateTopic != "pulsar/00000001/channel/2/value" || cfg.Device.SWVersion != "102" {
+		t.Errorf("unexpected discovery payload %+v", cfg)
+	}
+	if _, ok := m.Retained("homeassistant/binary_sensor/pulsar_00000001_problem/config"); !ok {
+		t.Error("problem sensor isn't discovered")
+	}
+
+	results := make(chan string, 4)
+	_ = m.Subscribe(ctx, "pulsar/00000001/result", 0, func(_ string, payload []byte) {
+		results <- string(payload)
+	})
+	_ = m.Publish(ctx, "pulsar/00000001/channel/2/set", 1, false, []byte("42"))
+	if res := waitResult(t, results); res != `{"command":"set 2 42"}` {
+		t.Errorf("unexpected result %s", res)
+	}
+	if v := d.Value(2); v != 42 {
+		t.Errorf("value isn't written %v", v)
+	}
+	retainedJSON(t, m, "pulsar/00000001/channel/2/value", &r)
+	if r.Value != 42 {
+		t.Errorf("written value isn't published %+v", r)
+	}
+	_ = m.Publish(ctx, "pulsar/00000001/channel/2/set", 1, false, []byte("x"))
+	var res struct{ Error string }
+	if err := json.Unmarshal([]byte(waitResult(t, results)), &res); err != nil || res.Error == "" {
+		t.Errorf("invalid command error isn't reported: %v", err)
+	}
+	_ = m.Publish(ctx, "pulsar/00000001/sync_time", 1, false, []byte("PRESS"))
+	if res := waitResult(t, results); res != `{"command":"sync_time"}` {
+		t.Errorf("unexpected result %s", res)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("unexpected error %v", err)
+	}
+	if msg, _ := m.Retained("pulsar/00000001/status"); string(msg.Payload) != "offline" {
+		t.Errorf("unexpected status %q", msg.Payload)
+	}
+
+	// a record written late is published on the next poll, published ones aren't repeated.
+	d.SetArchive(pulsar.Hourly, 2, time.Now().Add(-time.Hour), []float32{8})
+	published := len(m.Messages())
+	if err := b.publishArchive(context.Background(), b.devices[0], pulsar.Hourly); err != nil {
+		t.Fatal(err)
+	}
+	msgs := m.Messages()[published:]
+	if len(msgs) != 1 || msgs[0].Topic != "pulsar/00000001/archive/hourly/2" {
+		t.Errorf("unexpected messages %v", msgs)
+	}
+}
+
+// waitRetained waits for the retained message of the topic.
+func waitRetained(t *testing.T, m *MemoryBroker, topic, payload string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if msg, ok := m.Retained(topic); ok && string(msg.Payload) == payload {
+			return
+		}
+	}
+	t.Fatalf("%s isn't published", topic)
+}
+
+// retainedJSON decodes the retained message of the topic.
+func retainedJSON(t *testing.T, m *MemoryBroker, topic string, v interface{}) {
+	t.Helper()
+	msg, ok := m.Retained(topic)
+	if !ok {
+		t.Errorf("%s isn't published", topic)
+		return
+	}
+	if msg.QoS != 1 {
+		t.Errorf("%s: unexpected qos %d", topic, msg.QoS)
+	}
+	if err := json.Unmarshal(msg.Payload, v); err != nil {
+		t.Errorf("%s: %v", topic, err)
+	}
+}
+
+func waitResult(t *testing.T, results <-chan string) string {
+	t.Helper()
+	select {
+	case r := <-results:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("command result isn't published")
+		return ""
+	}
+}
@@ -0,0 +1,135 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Broker is an MQTT connection used by Bridge.
+// Implementations wrap an MQTT client library, MemoryBroker is an in-process implementation for tests.
+// Implementations must be safe for concurrent use.
+type Broker interface {
+	// Publish publishes payload to the topic.
+	Publish(ctx context.Context, topic string, qos byte, retained bool, payload []byte) error
+	// Subscribe calls handler for messages of topics matching filter. Filter may contain + and # wildcards.
+	Subscribe(ctx context.Context, filter string, qos byte, handler func(topic string, payload []byte)) error
+}
+
+// Message is a message published to MemoryBroker.
+type Message struct {
+	Topic    string
+	QoS      byte
+	Retained bool
+	Payload  []byte
+}
+
+// MemoryBroker is an in-process Broker. Subscribers receive retained messages on subscription
+// and published messages synchronously. MemoryBroker is safe for concurrent use.
+type MemoryBroker struct {
+	mu sync.Mutex
+	// retained messages by topic.
+	retained map[string]Message
+	// all published messages.
+	messages []Message
+	subs     []subscription
+}
+
+type subscription struct {
+	filter  string
+	handler func(topic string, payload []byte)
+}
+
+// NewMemoryBroker creates an in-process broker.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{retained: make(map[string]Message)}
+}
+
+// Publish publishes payload to the topic. Retained message with empty payload clears the retained one.
+func (m *MemoryBroker) Publish(ctx context.Context, topic string, qos byte, retained bool, payload []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if qos > 2 {
+		return fmt.Errorf("invalid qos %d", qos)
+	}
+	if topic == "" || strings.ContainsAny(topic, "+#") {
+		return fmt.Errorf("invalid topic %q", topic)
+	}
+	msg := Message{Topic: topic, QoS: qos, Retained: retained, Payload: append([]byte(nil), payload...)}
+	m.mu.Lock()
+	m.messages = append(m.messages, msg)
+	if retained {
+		if len(payload) == 0 {
+			delete(m.retained, topic)
+		} else {
+			m.retained[topic] = msg
+		}
+	}
+	var handlers []func(string, []byte)
+	for _, s := range m.subs {
+		if match(s.filter, topic) {
+			handlers = append(handlers, s.handler)
+		}
+	}
+	m.mu.Unlock()
+	for _, h := range handlers {
+		h(topic, msg.Payload)
+	}
+	return nil
+}
+
+// Subscribe calls handler for messages of topics matching filter, retained messages are delivered immediately.
+func (m *MemoryBroker) Subscribe(ctx context.Context, filter string, qos byte, handler func(topic string, payload []byte)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if qos > 2 {
+		return fmt.Errorf("invalid qos %d", qos)
+	}
+	m.mu.Lock()
+	m.subs = append(m.subs, subscription{filter: filter, handler: handler})
+	var retained []Message
+	for topic, msg := range m.retained {
+		if match(filter, topic) {
+			retained = append(retained, msg)
+		}
+	}
+	m.mu.Unlock()
+	for _, msg := range retained {
+		handler(msg.Topic, msg.Payload)
+	}
+	return nil
+}
+
+// Retained returns the retained message of the topic.
+func (m *MemoryBroker) Retained(topic string) (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg, ok := m.retained[topic]
+	return msg, ok
+}
+
+// Messages returns all published messages in order of publishing.
+func (m *MemoryBroker) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// match reports whether the topic matches the filter.
+func match(filter, topic string) bool {
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fs {
+		switch {
+		case f == "#":
+			return true
+		case i >= len(ts):
+			return false
+		case f != "+" && f != ts[i]:
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
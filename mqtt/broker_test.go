@@ -0,0 +1,59 @@
+package mqtt
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		exp           bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a/b/d", false},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a/b/c", true},
+		{"#", "a", true},
+		{"a/b/c", "a/b", false},
+	}
+	for _, test := range tests {
+		if got := match(test.filter, test.topic); got != test.exp {
+			t.Errorf("%q %q: expected %v", test.filter, test.topic, test.exp)
+		}
+	}
+}
+
+func TestMemoryBroker(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryBroker()
+	if err := m.Publish(ctx, "a/b", 1, true, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	if err := m.Subscribe(ctx, "a/+", 1, func(topic string, payload []byte) {
+		got = append(got, topic+"="+string(payload))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_ = m.Publish(ctx, "a/c", 0, false, []byte("2"))
+	_ = m.Publish(ctx, "b/c", 0, false, []byte("3"))
+	if len(got) != 2 || got[0] != "a/b=1" || got[1] != "a/c=2" {
+		t.Errorf("unexpected messages %v", got)
+	}
+	if _, ok := m.Retained("a/c"); ok {
+		t.Error("message isn't retained")
+	}
+	_ = m.Publish(ctx, "a/b", 1, true, nil)
+	if _, ok := m.Retained("a/b"); ok {
+		t.Error("retained message isn't cleared")
+	}
+	if err := m.Publish(ctx, "a/+", 0, false, nil); err == nil {
+		t.Error("wildcard topic is accepted")
+	}
+	if n := len(m.Messages()); n != 4 {
+		t.Errorf("unexpected number of messages %d", n)
+	}
+}
@@ -0,0 +1,107 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// haDevice is Home Assistant device description.
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+}
+
+// haEntity is Home Assistant entity discovery payload.
+type haEntity struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	StateTopic        string   `json:"state_topic,omitempty"`
+	ValueTemplate     string   `json:"value_template,omitempty"`
+	AvailabilityTopic string   `json:"availability_topic"`
+	StateClass        string   `json:"state_class,omitempty"`
+	DeviceClass       string   `json:"device_class,omitempty"`
+	EntityCategory    string   `json:"entity_category,omitempty"`
+	Unit              string   `json:"unit_of_measurement,omitempty"`
+	CommandTopic      string   `json:"command_topic,omitempty"`
+	Device            haDevice `json:"device"`
+}
+
+// discover publishes Home Assistant discovery payloads of the device:
+// a sensor per channel, clock drift sensor, problem binary sensor and sync time button.
+func (b *Bridge) discover(ctx context.Context, d *device) error {
+	addr := fmt.Sprintf("%08x", d.Client.Address())
+	id := "pulsar_" + addr
+	dev := haDevice{
+		Identifiers:  []string{id},
+		Name:         d.Name,
+		Manufacturer: "Teplovodokhran",
+		Model:        "Pulsar-M",
+	}
+	if fw, err := d.Client.FirmwareVersionContext(ctx); err == nil {
+		dev.SWVersion = fmt.Sprint(fw)
+	} else {
+		b.logf("%s: reading firmware version failed: %v", d.topic, err)
+	}
+	status := d.topic + "/status"
+
+	type entity struct {
+		// component and object id.
+		object string
+		haEntity
+	}
+	var entities []entity
+	for _, ch := range d.Channels {
+		entities = append(entities, entity{fmt.Sprintf("sensor/%s_%d", id, ch), haEntity{
+			Name:              fmt.Sprintf("%s channel %d", d.Name, ch),
+			UniqueID:          fmt.Sprintf("%s_%d", id, ch),
+			StateTopic:        fmt.Sprintf("%s/channel/%d/value", d.topic, ch),
+			ValueTemplate:     "{{ value_json.value }}",
+			AvailabilityTopic: status,
+			StateClass:        "total_increasing",
+			Device:            dev,
+		}})
+	}
+	entities = append(entities, entity{fmt.Sprintf("sensor/%s_clock_drift", id), haEntity{
+		Name:              d.Name + " clock drift",
+		UniqueID:          id + "_clock_drift",
+		StateTopic:        d.topic + "/diagnostics",
+		ValueTemplate:     "{{ value_json.clock_drift }}",
+		AvailabilityTopic: status,
+		DeviceClass:       "duration",
+		EntityCategory:    "diagnostic",
+		Unit:              "s",
+		Device:            dev,
+	}})
+	entities = append(entities, entity{fmt.Sprintf("binary_sensor/%s_problem", id), haEntity{
+		Name:              d.Name + " problem",
+		UniqueID:          id + "_problem",
+		StateTopic:        d.topic + "/diagnostics",
+		ValueTemplate:     "{{ 'ON' if value_json.problems else 'OFF' }}",
+		AvailabilityTopic: status,
+		DeviceClass:       "problem",
+		EntityCategory:    "diagnostic",
+		Device:            dev,
+	}})
+	entities = append(entities, entity{fmt.Sprintf("button/%s_sync_time", id), haEntity{
+		Name:              d.Name + " sync time",
+		UniqueID:          id + "_sync_time",
+		AvailabilityTopic: status,
+		CommandTopic:      d.topic + "/sync_time",
+		EntityCategory:    "config",
+		Device:            dev,
+	}})
+	for _, e := range entities {
+		payload, err := json.Marshal(e.haEntity)
+		if err != nil {
+			return err
+		}
+		if err := b.broker.Publish(ctx, b.opts.DiscoveryPrefix+"/"+e.object+"/config", b.opts.QoS, true, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package pulsar
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Quality is a quality of a polled value.
+type Quality uint8
+
+const (
+	// QualityGood means that the value is read from device.
+	QualityGood Quality = iota
+	// QualityInvalid means that device returned not a finite number.
+	QualityInvalid
+	// QualityStale means that the request failed and the value is the last one read from device.
+	QualityStale
+	// QualityBad means that the request failed and no value was read from device yet, the value is NaN.
+	QualityBad
+)
+
+func (q Quality) String() string {
+	switch q {
+	case QualityGood:
+		return "good"
+	case QualityInvalid:
+		return "invalid"
+	case QualityStale:
+		return "stale"
+	case QualityBad:
+		return "bad"
+	default:
+		return "unknown"
+	}
+}
+
+// PollTarget is a device polled by Poller.
+type PollTarget struct {
+	// Device client. Devices sharing a connection must have clients of the same Bus.
+	Client *Client
+	// Channels to read. May be empty if the poller has a poll handler, see WithPollHandler.
+	Channels []uint
+	// Polling interval.
+	Interval time.Duration
+}
+
+// Reading is a polled channel value.
+type Reading struct {
+	// Device address.
+	Address uint32
+	// Channel number.
+	Channel uint
+	// Channel value.
+	Value float64
+	// Time of the reading, i.e. local clock at the middle of request round trip.
+	// For a failed request it is the time of the failure.
+	Time time.Time
+	// Value quality.
+	Quality Quality
+	// Request error if the request failed.
+	Err error
+}
+
+// PollState is a polling state of a device.
+type PollState struct {
+	// Time of the last successful request.
+	LastSuccess time.Time
+	// Time of the last failed request.
+	LastFailure time.Time
+	// Error of the last failed request.
+	LastError error
+	// Number of consecutive failed requests.
+	Failures int
+}
+
+// PollerOption configures a Poller.
+type PollerOption func(p *Poller)
+
+// WithHandler sets a function readings are passed to instead of Readings channel.
+// Handler is called from polling goroutines, a slow handler delays polling of devices on the same connection.
+func WithHandler(h func(Reading)) PollerOption {
+	return func(p *Poller) {
+		p.handler = h
+	}
+}
+
+// WithPollHandler sets a function readings of every target poll are passed to instead of Readings channel.
+// It is called from the polling goroutine of the target connection along with the target client,
+// so requests it makes, e.g. archive reads, are serialized with polling of devices on the connection.
+// Readings are empty for a target without channels.
+func WithPollHandler(h func(ctx context.Context, c *Client, rs []Reading)) PollerOption {
+	return func(p *Poller) {
+		p.pollHandler = h
+	}
+}
+
+// WithReadingsBuffer sets Readings channel capacity. Defaults to 64.
+func WithReadingsBuffer(n int) PollerOption {
+	return func(p *Poller) {
+		p.buffer = n
+	}
+}
+
+// Poller reads current values of devices periodically.
+// Requests of devices sharing a connection are made one by one, so they never overlap on a bus
+// and a slow device delays polling of the others. Devices on different connections are polled concurrently.
+type Poller struct {
+	// targets grouped by connection.
+	lines map[*line][]*pollTask
+	// readings handler, nil if readings are sent to the channel.
+	handler func(Reading)
+	// handler of target polls, nil if readings are sent to the channel or handler.
+	pollHandler func(ctx context.Context, c *Client, rs []Reading)
+	// readings channel capacity.
+	buffer int
+	// readings channel.
+	readings chan Reading
+
+	mu sync.Mutex
+	// polling state by device address.
+	states map[uint32]*PollState
+	// last read values by device address and channel.
+	last map[uint32]map[uint]float64
+}
+
+// pollTask is a scheduled polling of a target.
+type pollTask struct {
+	PollTarget
+	// next polling time.
+	due time.Time
+}
+
+// NewPoller creates a poller of targets.
+func NewPoller(targets []PollTarget, opts ...PollerOption) (*Poller, error) {
+	p := &Poller{
+		lines:  make(map[*line][]*pollTask),
+		buffer: 64,
+		states: make(map[uint32]*PollState),
+		last:   make(map[uint32]map[uint]float64),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("at least a single target is required")
+	}
+	for i, t := range targets {
+		if t.Client == nil {
+			return nil, fmt.Errorf("target %d: client is required", i)
+		}
+		if t.Interval <= 0 {
+			return nil, fmt.Errorf("target %d: polling interval must be positive", i)
+		}
+		if len(t.Channels) > 0 || p.pollHandler == nil {
+			if err := validateChannels(t.Channels...); err != nil {
+				return nil, fmt.Errorf("target %d: %w", i, err)
+			}
+		}
+		t.Channels = append([]uint(nil), t.Channels...)
+		sort.Slice(t.Channels, func(i, j int) bool { return t.Channels[i] < t.Channels[j] })
+		p.lines[t.Client.line] = append(p.lines[t.Client.line], &pollTask{PollTarget: t})
+		p.states[t.Client.address] = &PollState{}
+	}
+	if p.handler == nil && p.pollHandler == nil {
+		p.readings = make(chan Reading, p.buffer)
+	}
+	return p, nil
+}
+
+// Readings returns the channel of polled values. The channel is closed when Run returns.
+// It is nil if readings handler is set with WithHandler or WithPollHandler.
+func (p *Poller) Readings() <-chan Reading {
+	return p.readings
+}
+
+// Run polls devices until ctx is done. Returns ctx error.
+// Run must be called once.
+func (p *Poller) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	wg.Add(len(p.lines))
+	for _, tasks := range p.lines {
+		go func(tasks []*pollTask) {
+			defer wg.Done()
+			p.poll(ctx, tasks)
+		}(tasks)
+	}
+	wg.Wait()
+	if p.readings != nil {
+		close(p.readings)
+	}
+	return ctx.Err()
+}
+
+// State returns polling state of the device.
+func (p *Poller) State(address uint32) (PollState, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.states[address]
+	if !ok {
+		return PollState{}, false
+	}
+	return *s, true
+}
+
+// States returns polling states by device address.
+func (p *Poller) States() map[uint32]PollState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rv := make(map[uint32]PollState, len(p.states))
+	for addr, s := range p.states {
+		rv[addr] = *s
+	}
+	return rv
+}
+
+// poll runs tasks of a connection in order of their due time until ctx is done.
+func (p *Poller) poll(ctx context.Context, tasks []*pollTask) {
+	now := time.Now()
+	for _, t := range tasks {
+		t.due = now
+	}
+	for {
+		next := tasks[0]
+		for _, t := range tasks[1:] {
+			if t.due.Before(next.due) {
+				next = t
+			}
+		}
+		if err := sleep(ctx, time.Until(next.due)); err != nil {
+			return
+		}
+		p.read(ctx, next)
+		// overrun polls are skipped.
+		next.due = next.due.Add(next.Interval)
+		for now := time.Now(); !next.due.After(now); {
+			next.due = next.due.Add(next.Interval)
+		}
+	}
+}
+
+// read polls a target and emits its readings.
+func (p *Poller) read(ctx context.Context, t *pollTask) {
+	var rs []Reading
+	if len(t.Channels) > 0 {
+		sent := time.Now()
+		values, err := t.Client.CurValuesContext(ctx, t.Channels...)
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		at := time.Now()
+		if err == nil {
+			at = sent.Add(at.Sub(sent) / 2)
+		}
+		rs = p.update(t, at, values, err)
+	}
+	if p.pollHandler != nil {
+		p.pollHandler(ctx, t.Client, rs)
+		return
+	}
+	for _, r := range rs {
+		if p.handler != nil {
+			p.handler(r)
+			continue
+		}
+		select {
+		case p.readings <- r:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// update records polling result of a target made at the time and returns its readings.
+func (p *Poller) update(t *pollTask, at time.Time, values []Channel, err error) []Reading {
+	addr := t.Client.address
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, last := p.states[addr], p.last[addr]
+	if last == nil {
+		last = make(map[uint]float64)
+		p.last[addr] = last
+	}
+	rv := make([]Reading, 0, len(t.Channels))
+	if err != nil {
+		s.LastFailure, s.LastError = at, err
+		s.Failures++
+		for _, ch := range t.Channels {
+			r := Reading{Address: addr, Channel: ch, Value: math.NaN(), Time: at, Quality: QualityBad, Err: err}
+			if v, ok := last[ch]; ok {
+				r.Value, r.Quality = v, QualityStale
+			}
+			rv = append(rv, r)
+		}
+		return rv
+	}
+	s.LastSuccess = at
+	s.Failures = 0
+	for _, v := range values {
+		r := Reading{Address: addr, Channel: v.Id, Value: v.Value, Time: at}
+		if math.IsNaN(v.Value) || math.IsInf(v.Value, 0) {
+			r.Quality = QualityInvalid
+		} else {
+			last[v.Id] = v.Value
+		}
+		rv = append(rv, r)
+	}
+	return rv
+}
@@ -0,0 +1,144 @@
+package pulsar_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+)
+
+func TestPoller(t *testing.T) {
+	s, b := startBus(t, 1, 2)
+	s.Device(1).SetValue(1, 10)
+	s.Device(2).SetValue(3, 30)
+	var targets []pulsar.PollTarget
+	for _, addr := range []string{"00000001", "00000002", "00000003"} {
+		c, err := b.Client(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, pulsar.PollTarget{Client: c, Channels: []uint{3, 1}, Interval: 100 * time.Millisecond})
+	}
+	targets[0].Interval = 20 * time.Millisecond
+
+	var mu sync.Mutex
+	readings := make(map[uint32][]pulsar.Reading)
+	p, err := pulsar.NewPoller(targets, pulsar.WithHandler(func(r pulsar.Reading) {
+		mu.Lock()
+		defer mu.Unlock()
+		readings[r.Address] = append(readings[r.Address], r)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := p.Run(ctx); err != context.DeadlineExceeded {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if n1, n2 := len(readings[1]), len(readings[2]); n1 <= n2 || n2 < 2 {
+		t.Errorf("polling intervals aren't followed: %d and %d readings", n1, n2)
+	}
+	for _, r := range readings[2] {
+		if r.Quality != pulsar.QualityGood || r.Err != nil || r.Time.IsZero() {
+			t.Errorf("unexpected reading %+v", r)
+		}
+		if r.Channel == 3 && r.Value != 30 || r.Channel == 1 && r.Value != 0 {
+			t.Errorf("unexpected value %+v", r)
+		}
+	}
+	if len(readings[3]) == 0 {
+		t.Fatal("failed polls aren't reported")
+	}
+	for _, r := range readings[3] {
+		if r.Quality != pulsar.QualityBad || r.Err == nil || !math.IsNaN(r.Value) {
+			t.Errorf("unexpected reading %+v", r)
+		}
+	}
+
+	st := p.States()
+	if st[1].LastSuccess.IsZero() || st[1].Failures != 0 || st[1].LastError != nil {
+		t.Errorf("unexpected state %+v", st[1])
+	}
+	if !st[3].LastSuccess.IsZero() || st[3].Failures == 0 || st[3].LastError == nil {
+		t.Errorf("unexpected state %+v", st[3])
+	}
+}
+
+func TestPollerStale(t *testing.T) {
+	s, b := startBus(t, 1)
+	s.Device(1).SetValue(2, 5)
+	c, _ := b.Client("00000001")
+	p, err := pulsar.NewPoller([]pulsar.PollTarget{{Client: c, Channels: []uint{2}, Interval: 20 * time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.Run(ctx) }()
+
+	if r := <-p.Readings(); r.Quality != pulsar.QualityGood || r.Value != 5 {
+		t.Errorf("unexpected reading %+v", r)
+	}
+	_ = s.Close()
+	for r := range p.Readings() {
+		if r.Err != nil {
+			if r.Quality != pulsar.QualityStale || r.Value != 5 {
+				t.Errorf("unexpected reading %+v", r)
+			}
+			break
+		}
+	}
+	cancel()
+	for range p.Readings() {
+	}
+	if err := <-done; err != context.Canceled {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestPollHandler(t *testing.T) {
+	s, b := startBus(t, 1)
+	s.Device(1).SetValue(2, 5)
+	c, _ := b.Client("00000001")
+	var mu sync.Mutex
+	var values, polls int
+	p, err := pulsar.NewPoller([]pulsar.PollTarget{
+		{Client: c, Channels: []uint{2}, Interval: 20 * time.Millisecond},
+		{Client: c, Interval: 20 * time.Millisecond},
+	}, pulsar.WithPollHandler(func(ctx context.Context, c *pulsar.Client, rs []pulsar.Reading) {
+		// requests of the handler are serialized with polling.
+		if _, err := c.FirmwareVersionContext(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("handler request failed: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		polls++
+		for _, r := range rs {
+			if r.Value == 5 {
+				values++
+			}
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Readings() != nil {
+		t.Error("readings channel is created")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_ = p.Run(ctx)
+	mu.Lock()
+	defer mu.Unlock()
+	if values == 0 || polls <= values {
+		t.Errorf("unexpected polls %d with %d values", polls, values)
+	}
+}
@@ -0,0 +1,76 @@
+package pulsar
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrQueueFull is returned when too many requests are waiting for the connection.
+var ErrQueueFull = errors.New("request queue is full")
+
+// queue serializes frame exchanges on a connection.
+// Waiting requests are granted access in the order of arrival.
+type queue struct {
+	mu sync.Mutex
+	// maximum number of waiting requests. Zero means unlimited.
+	limit int
+	// true if connection is taken by a request.
+	busy bool
+	// wake up channels of waiting requests.
+	waiters list.List
+}
+
+// acquire blocks until connection is available or ctx is done.
+func (q *queue) acquire(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.busy {
+		q.busy = true
+		q.mu.Unlock()
+		return nil
+	}
+	if q.limit > 0 && q.waiters.Len() >= q.limit {
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+	ready := make(chan struct{})
+	el := q.waiters.PushBack(ready)
+	q.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		select {
+		case <-ready:
+			// connection has been handed over concurrently, pass it to the next one.
+			q.mu.Unlock()
+			q.release()
+		default:
+			q.waiters.Remove(el)
+			q.mu.Unlock()
+		}
+		return ctx.Err()
+	}
+}
+
+// release hands connection over to the first waiting request or marks it as available.
+func (q *queue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if el := q.waiters.Front(); el != nil {
+		q.waiters.Remove(el)
+		close(el.Value.(chan struct{}))
+		return
+	}
+	q.busy = false
+}
+
+// len returns number of waiting requests.
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.waiters.Len()
+}
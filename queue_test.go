@@ -0,0 +1,61 @@
+package pulsar
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueueOrder(t *testing.T) {
+	var q queue
+	if err := q.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	cnt := 5
+	order := make(chan int, cnt)
+	for i := 0; i < cnt; i++ {
+		go func(i int) {
+			_ = q.acquire(context.Background())
+			order <- i
+			q.release()
+		}(i)
+		for q.len() != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	q.release()
+	for i := 0; i < cnt; i++ {
+		if v := <-order; v != i {
+			t.Errorf("waiters are served out of order: expected %d, got %d", i, v)
+		}
+	}
+}
+
+func TestQueueLimit(t *testing.T) {
+	q := queue{limit: 1}
+	_ = q.acquire(context.Background())
+	go func() { _ = q.acquire(context.Background()) }()
+	for q.len() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := q.acquire(context.Background()); err != ErrQueueFull {
+		t.Errorf("expected %v, got %v", ErrQueueFull, err)
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	var q queue
+	_ = q.acquire(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if q.len() != 0 {
+		t.Error("canceled request is left in the queue")
+	}
+	q.release()
+	if err := q.acquire(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
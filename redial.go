@@ -0,0 +1,163 @@
+package pulsar
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// redialConn is a connection that re-establishes the underlying connection after i/o failures.
+// Broken connection is closed and the new one is dialed on the next frame operation.
+type redialConn struct {
+	// establishes underlying connection.
+	dial func() (Conn, error)
+	// underlying connection, nil if broken.
+	conn Conn
+	// true if connection is closed by user.
+	closed bool
+	// context of current frame exchange.
+	ctx context.Context
+	// releases underlying connection binding to ctx.
+	release func()
+}
+
+// creates auto redialing connection. The first connection is established immediately.
+func newRedialConn(dial func() (Conn, error)) (*redialConn, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return &redialConn{dial: dial, conn: conn}, nil
+}
+
+// connect returns underlying connection, dialing it if broken.
+func (c *redialConn) connect() (Conn, error) {
+	if c.closed {
+		return nil, net.ErrClosed
+	}
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	if c.ctx != nil {
+		c.bind()
+	}
+	return conn, nil
+}
+
+// check marks underlying connection as broken if err isn't a timeout or a context error.
+// Timeouts are expected on a bus, e.g. if device is offline, and cancelled requests don't break
+// the connection, so it is kept.
+func (c *redialConn) check(err error) error {
+	var ne net.Error
+	if err == nil || c.conn == nil || (errors.As(err, &ne) && ne.Timeout()) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if c.release != nil {
+		c.release()
+		c.release = nil
+	}
+	_ = c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// PrepareWrite configures frame writing operation dialing the connection if it is broken.
+func (c *redialConn) PrepareWrite() error {
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	return c.check(conn.PrepareWrite())
+}
+
+// PrepareRead configures frame reading operation dialing the connection if it is broken.
+func (c *redialConn) PrepareRead() error {
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	return c.check(conn.PrepareRead())
+}
+
+// LogRequest logs written frame.
+func (c *redialConn) LogRequest() {
+	if c.conn != nil {
+		c.conn.LogRequest()
+	}
+}
+
+// LogResponse logs received frame.
+func (c *redialConn) LogResponse() {
+	if c.conn != nil {
+		c.conn.LogResponse()
+	}
+}
+
+// Write writes data into the connection.
+func (c *redialConn) Write(p []byte) (int, error) {
+	if c.conn == nil {
+		return 0, net.ErrClosed
+	}
+	n, err := c.conn.Write(p)
+	return n, c.check(err)
+}
+
+// Read reads up to len(p) bytes into p.
+func (c *redialConn) Read(p []byte) (int, error) {
+	if c.conn == nil {
+		return 0, net.ErrClosed
+	}
+	n, err := c.conn.Read(p)
+	return n, c.check(err)
+}
+
+// Flush writes any buffered data to the underlying connection.
+func (c *redialConn) Flush() error {
+	if c.conn == nil {
+		return net.ErrClosed
+	}
+	return c.check(c.conn.Flush())
+}
+
+// Close closes the connection. It isn't redialed afterwards.
+func (c *redialConn) Close() error {
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+	if c.release != nil {
+		c.release()
+		c.release = nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Bind makes frame operations honor ctx deadline and cancellation.
+func (c *redialConn) Bind(ctx context.Context) func() {
+	c.ctx = ctx
+	if c.conn != nil {
+		c.bind()
+	}
+	return func() {
+		if c.release != nil {
+			c.release()
+			c.release = nil
+		}
+		c.ctx = nil
+	}
+}
+
+// binds underlying connection to the current context.
+func (c *redialConn) bind() {
+	if cc, ok := c.conn.(ContextConn); ok {
+		c.release = cc.Bind(c.ctx)
+	}
+}
@@ -0,0 +1,83 @@
+package pulsar
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRedial(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("tcp is not available: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		// the first connection is dropped as converter reboots.
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_ = conn.Close()
+		conn, err = l.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		serveDevice(conn, func(fn byte, payload []byte) []byte {
+			return []byte{0x66, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+		})
+	}()
+
+	d := Dialer{RWTimeOut: time.Second, Redial: true}
+	conn, err := d.DialTCP(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	cl, _ := NewClient("01020304", conn, WithRetry(RetryPolicy{MaxAttempts: 3, Backoff: 10 * time.Millisecond}))
+	v, err := cl.FirmwareVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 102 {
+		t.Error("response decoding failed.")
+	}
+}
+
+func TestRedialClosed(t *testing.T) {
+	var m mockConn
+	conn, _ := newRedialConn(func() (Conn, error) {
+		return newConn(&m, nil, time.Second), nil
+	})
+	_ = conn.Close()
+	if !m.closed {
+		t.Error("underlying connection isn't closed")
+	}
+	if err := conn.PrepareWrite(); err != net.ErrClosed {
+		t.Errorf("closed connection is redialed: %v", err)
+	}
+}
+
+func TestRedialCancel(t *testing.T) {
+	var m mockConn
+	var dials int
+	conn, _ := newRedialConn(func() (Conn, error) {
+		dials++
+		return newConn(&m, nil, time.Second), nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	release := conn.Bind(ctx)
+	if err := conn.PrepareRead(); err != context.Canceled {
+		t.Errorf("unexpected error %v", err)
+	}
+	release()
+	if err := conn.PrepareRead(); err != nil {
+		t.Fatal(err)
+	}
+	if m.closed || dials != 1 {
+		t.Error("connection is dropped on cancellation")
+	}
+}
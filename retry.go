@@ -0,0 +1,109 @@
+package pulsar
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// RetryPolicy configures retries of requests failed due to transient transport failures.
+type RetryPolicy struct {
+	// Maximum number of attempts including the first one. Values below 2 disable retries.
+	MaxAttempts int
+	// Delay before the first retry. It is doubled for every next retry.
+	Backoff time.Duration
+	// Maximum delay between retries. Zero means unlimited.
+	MaxBackoff time.Duration
+	// Fraction of a delay that is randomly added to or subtracted from it, 0..1.
+	Jitter float64
+	// Retryable reports whether a request failed with err can be retried. Defaults to IsRetryable.
+	Retryable func(err error) bool
+}
+
+// WithRetry configures retry policy for client requests.
+func WithRetry(p RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retry = p
+	}
+}
+
+// IsRetryable reports whether err is a transient transport failure:
+// crc or message id mismatch, invalid or truncated frame, i/o timeout or broken connection.
+// Errors returned by device, i.e. ProtocolError, and context errors are never retryable.
+func IsRetryable(err error) bool {
+	var pe *ProtocolError
+	switch {
+	case err == nil,
+		errors.As(err, &pe),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, ErrCRC),
+		errors.Is(err, ErrInvalidFrame),
+		errors.Is(err, ErrTooShort),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, net.ErrClosed):
+		return true
+	}
+	var ie *IdMismatchError
+	if errors.As(err, &ie) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	var oe *net.OpError
+	return errors.As(err, &oe)
+}
+
+// shouldRetry reports whether a request failed with err at attempt (counting from 1) should be retried.
+func (p RetryPolicy) shouldRetry(attempt int, err error) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// delay returns a pause before the next attempt.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d += time.Duration(p.Jitter * (2*rand.Float64() - 1) * float64(d))
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// sleep pauses for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
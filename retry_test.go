@@ -0,0 +1,117 @@
+package pulsar
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err error
+		exp bool
+	}{
+		{nil, false},
+		{ErrCRC, true},
+		{ErrInvalidFrame, true},
+		{&IdMismatchError{Expected: 1, Got: 2}, true},
+		{io.EOF, true},
+		{fmt.Errorf("wrapped: %w", ErrCRC), true},
+		{&net.OpError{Op: "read", Err: fmt.Errorf("connection reset")}, true},
+		{&ProtocolError{IllegalAccess}, false},
+		{context.Canceled, false},
+		{ErrQueueFull, false},
+	}
+	for _, test := range tests {
+		if IsRetryable(test.err) != test.exp {
+			t.Errorf("IsRetryable(%v) expected %v", test.err, test.exp)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	exp := []time.Duration{10, 20, 40, 50, 50}
+	for i, d := range exp {
+		if p.delay(i+1) != d*time.Millisecond {
+			t.Errorf("attempt %d: expected delay %v, got %v", i+1, d*time.Millisecond, p.delay(i+1))
+		}
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.delay(1); d < 5*time.Millisecond || d > 15*time.Millisecond {
+			t.Errorf("jitter is out of range: %v", d)
+		}
+	}
+}
+
+// serveFlaky emulates a device that corrupts the first fail responses and replies with err code if set.
+func serveFlaky(conn net.Conn, fail int, code ErrorCode) *int {
+	var requests int
+	go func() {
+		for {
+			req := make([]byte, 12)
+			if _, err := io.ReadFull(conn, req); err != nil {
+				return
+			}
+			requests++
+			resp := append([]byte{}, req[:4]...)
+			if code != 0 {
+				resp = append(resp, 0x00, 0x0B, byte(code), req[8], req[9])
+			} else {
+				resp = append(resp, 0x0A, 0x12, 0x66, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, req[8], req[9])
+			}
+			check := generateCRC(resp)
+			if requests <= fail {
+				check[0]++
+			}
+			if _, err := conn.Write(append(resp, check...)); err != nil {
+				return
+			}
+		}
+	}()
+	return &requests
+}
+
+func TestClientRetry(t *testing.T) {
+	dev, host := net.Pipe()
+	defer func() { _ = dev.Close() }()
+	requests := serveFlaky(dev, 2, 0)
+	cl, _ := NewClient("01020304", newConn(host, nil, time.Second), WithRetry(RetryPolicy{MaxAttempts: 3}))
+	v, err := cl.FirmwareVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 102 || *requests != 3 {
+		t.Errorf("expected 3 requests, got %d", *requests)
+	}
+}
+
+func TestClientRetryExhausted(t *testing.T) {
+	dev, host := net.Pipe()
+	defer func() { _ = dev.Close() }()
+	requests := serveFlaky(dev, 5, 0)
+	cl, _ := NewClient("01020304", newConn(host, nil, time.Second), WithRetry(RetryPolicy{MaxAttempts: 2}))
+	if _, err := cl.FirmwareVersion(); err != ErrCRC {
+		t.Errorf("expected %v, got %v", ErrCRC, err)
+	}
+	if *requests != 2 {
+		t.Errorf("expected 2 requests, got %d", *requests)
+	}
+}
+
+func TestClientNoRetryOnProtocolError(t *testing.T) {
+	dev, host := net.Pipe()
+	defer func() { _ = dev.Close() }()
+	requests := serveFlaky(dev, 0, IllegalAccess)
+	cl, _ := NewClient("01020304", newConn(host, nil, time.Second), WithRetry(RetryPolicy{MaxAttempts: 3}))
+	if _, err := cl.FirmwareVersion(); err == nil {
+		t.Error("error response isn't reported")
+	}
+	if *requests != 1 {
+		t.Errorf("protocol error is retried, %d requests sent", *requests)
+	}
+}
@@ -0,0 +1,172 @@
+package pulsar
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sort"
+	"time"
+)
+
+// default discovery replies collection window.
+const scanWindow = 3 * time.Second
+
+// DeviceInfo describes a device found on a bus.
+type DeviceInfo struct {
+	// Device network address.
+	Address uint32
+	// Model id.
+	Model uint16
+	// Firmware version.
+	Firmware uint16
+}
+
+// ScanOptions configures a bus scan.
+type ScanOptions struct {
+	// Addresses to probe with model requests one by one.
+	// If empty, broadcast discovery is sent and all replies within Window are collected.
+	Addresses []uint32
+	// Discovery replies collection window. Defaults to 3 seconds.
+	Window time.Duration
+	// Timeout of a single address probe. Defaults to connection's i/o timeout.
+	ProbeTimeout time.Duration
+	// Progress is called after each probed address with the number of processed and total addresses.
+	// For broadcast discovery the total is the number of devices replied and it is called after each of them is probed.
+	Progress func(done, total int)
+}
+
+// Scan enumerates devices on the connection. See Bus.Scan.
+func Scan(ctx context.Context, conn Conn, opts ScanOptions) ([]DeviceInfo, error) {
+	return NewBus(conn).Scan(ctx, opts)
+}
+
+// Scan enumerates devices on the bus and creates clients for every device found.
+// Devices are ordered by address. If ctx is done devices found so far are returned along with ctx.Err().
+func (b *Bus) Scan(ctx context.Context, opts ScanOptions) ([]DeviceInfo, error) {
+	addrs := opts.Addresses
+	broadcast := len(addrs) == 0
+	if broadcast {
+		var err error
+		if addrs, err = b.discover(ctx, opts.Window); err != nil {
+			return nil, err
+		}
+	}
+
+	var rv []DeviceInfo
+	for i, addr := range addrs {
+		info, err := b.probe(ctx, addr, opts.ProbeTimeout)
+		if ctx.Err() != nil {
+			return rv, ctx.Err()
+		}
+		if err == nil {
+			rv = append(rv, info)
+		}
+		if opts.Progress != nil {
+			opts.Progress(i+1, len(addrs))
+		}
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Address < rv[j].Address })
+	return rv, nil
+}
+
+// probe queries device model and firmware version.
+// Firmware version is left blank if device doesn't report it.
+func (b *Bus) probe(ctx context.Context, address uint32, to time.Duration) (DeviceInfo, error) {
+	info := DeviceInfo{Address: address}
+	c := b.client(address)
+	pctx := ctx
+	if to > 0 {
+		var cancel context.CancelFunc
+		pctx, cancel = context.WithTimeout(ctx, to)
+		defer cancel()
+	}
+	var err error
+	if info.Model, err = c.ModelContext(pctx); err != nil {
+		b.forget(address)
+		return info, err
+	}
+	info.Firmware, _ = c.FirmwareVersionContext(pctx)
+	return info, nil
+}
+
+// forget removes the client of a device that doesn't respond.
+func (b *Bus) forget(address uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.clients, address)
+}
+
+// discover sends broadcast discovery message and collects addresses of devices replied within the window.
+// Corrupted replies, e.g. due to collisions, are skipped.
+func (b *Bus) discover(ctx context.Context, window time.Duration) ([]uint32, error) {
+	if window == 0 {
+		window = scanWindow
+	}
+	if err := b.l.q.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer b.l.q.release()
+
+	wctx, cancel := context.WithTimeout(ctx, window)
+	defer cancel()
+
+	found := make(map[uint32]bool)
+	conn := b.l.conn
+	err := withContext(wctx, conn, func() error {
+		if err := conn.PrepareWrite(); err != nil {
+			return err
+		}
+		if _, err := conn.Write(discoveryMessage); err != nil {
+			return err
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+		conn.LogRequest()
+
+		if err := conn.PrepareRead(); err != nil {
+			return err
+		}
+		var buf []byte
+		chunk := make([]byte, 64)
+		for {
+			n, err := conn.Read(chunk)
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() && wctx.Err() == nil {
+				// i/o timeout is shorter than the window, nothing is buffered on timeout so reading may be re-armed.
+				if err := conn.PrepareRead(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			buf = append(buf, chunk[:n]...)
+			for len(buf) >= minFrameLen {
+				if !bytes.HasPrefix(buf, discoveryMessage[:4]) || checkCrc(buf[:minFrameLen]) != nil {
+					buf = buf[1:]
+					continue
+				}
+				conn.LogResponse()
+				found[binary.BigEndian.Uint32(buf[4:8])] = true
+				buf = buf[minFrameLen:]
+			}
+		}
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return nil, err
+	}
+
+	rv := make([]uint32, 0, len(found))
+	for addr := range found {
+		rv = append(rv, addr)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i] < rv[j] })
+	return rv, nil
+}
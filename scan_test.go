@@ -0,0 +1,150 @@
+package pulsar
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveBus emulates devices with addresses on conn. Devices answer discovery, model and firmware version requests.
+func serveBus(conn net.Conn, addrs ...uint32) {
+	online := make(map[uint32]bool)
+	for _, a := range addrs {
+		online[a] = true
+	}
+	for {
+		head := make([]byte, 6)
+		if _, err := io.ReadFull(conn, head); err != nil {
+			return
+		}
+		addr := binary.BigEndian.Uint32(head)
+		var req []byte
+		switch {
+		case bytes.HasPrefix(head, discoveryMessage[:4]), head[4] == discoveryModel[0] && head[5] == discoveryModel[1]:
+			req = append(head, make([]byte, 5)...)
+		default:
+			req = append(head, make([]byte, int(head[5])-len(head))...)
+		}
+		if _, err := io.ReadFull(conn, req[len(head):]); err != nil {
+			return
+		}
+
+		var out []byte
+		switch {
+		case bytes.HasPrefix(head, discoveryMessage[:4]):
+			// replies collide with garbage in between.
+			out = append(out, 0xFF, 0x00)
+			for _, a := range addrs {
+				resp := append([]byte{}, discoveryMessage[:4]...)
+				resp = append(resp, 0, 0, 0, 0)
+				binary.BigEndian.PutUint32(resp[4:], a)
+				out = append(out, append(resp, generateCRC(resp)...)...)
+			}
+		case !online[addr]:
+			continue
+		case head[4] == discoveryModel[0]:
+			resp := append([]byte{}, head[:4]...)
+			resp = append(resp, 0x03, 0x02, 0x9A, 0x00)
+			out = append(resp, generateCRC(resp)...)
+		default:
+			ln := len(req)
+			resp := append([]byte{}, req[:5]...)
+			resp = append(resp, 0x12, 0x66, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+			resp = append(resp, req[ln-4:ln-2]...)
+			out = append(resp, generateCRC(resp)...)
+		}
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+func TestScanBroadcast(t *testing.T) {
+	dev, host := net.Pipe()
+	defer func() { _ = dev.Close() }()
+	go serveBus(dev, 0x12345678, 0x00000001)
+
+	b := NewBus(newConn(host, nil, time.Second))
+	var progress int
+	devs, err := b.Scan(context.Background(), ScanOptions{
+		Window:   100 * time.Millisecond,
+		Progress: func(done, total int) { progress = done },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devs) != 2 || devs[0].Address != 1 || devs[1].Address != 0x12345678 {
+		t.Fatalf("wrong devices found: %+v", devs)
+	}
+	if devs[0].Model != 0x9A00 || devs[0].Firmware != 102 {
+		t.Errorf("wrong device info: %+v", devs[0])
+	}
+	if progress != 2 {
+		t.Error("progress isn't reported")
+	}
+	if len(b.Clients()) != 2 {
+		t.Error("clients aren't created for devices found")
+	}
+}
+
+func TestScanAddresses(t *testing.T) {
+	dev, host := net.Pipe()
+	defer func() { _ = dev.Close() }()
+	go serveBus(dev, 0x00000002)
+
+	devs, err := Scan(context.Background(), newConn(host, nil, time.Second), ScanOptions{
+		Addresses:    []uint32{1, 2, 3},
+		ProbeTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devs) != 1 || devs[0].Address != 2 {
+		t.Fatalf("wrong devices found: %+v", devs)
+	}
+}
+
+func TestScanCancel(t *testing.T) {
+	dev, host := net.Pipe()
+	defer func() { _ = dev.Close() }()
+	go serveBus(dev)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := NewBus(newConn(host, nil, time.Second))
+	_, err := b.Scan(ctx, ScanOptions{
+		Addresses: []uint32{1, 2, 3},
+		Progress:  func(done, total int) { cancel() },
+	})
+	if err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestScanLongWindow(t *testing.T) {
+	dev, host := net.Pipe()
+	defer func() { _ = dev.Close() }()
+	go func() {
+		req := make([]byte, len(discoveryMessage))
+		if _, err := io.ReadFull(dev, req); err != nil {
+			return
+		}
+		// the reply comes after the i/o timeout but within the window.
+		time.Sleep(150 * time.Millisecond)
+		resp := append([]byte{}, discoveryMessage[:4]...)
+		resp = append(resp, 0, 0, 0, 5)
+		_, _ = dev.Write(append(resp, generateCRC(resp)...))
+	}()
+
+	b := NewBus(newConn(host, nil, 50*time.Millisecond))
+	addrs, err := b.discover(context.Background(), 400*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != 5 {
+		t.Errorf("wrong devices found: %v", addrs)
+	}
+}
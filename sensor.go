@@ -0,0 +1,163 @@
+package pulsar
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// LineTestWarning is a warning to show before running a line test.
+const LineTestWarning = "line test suppresses counting for up to 200ms, pulses may be lost"
+
+// SensorState is a sensor test result of a channel.
+type SensorState uint8
+
+const (
+	// SensorNotTested means that the channel wasn't tested.
+	SensorNotTested SensorState = iota
+	// SensorOK means that the line is fine.
+	SensorOK
+	// SensorShorted means that the sensor is closed.
+	SensorShorted
+	// SensorOpen means that the sensor is open or the line is broken.
+	SensorOpen
+)
+
+func (s SensorState) String() string {
+	switch s {
+	case SensorNotTested:
+		return "not tested"
+	case SensorOK:
+		return "ok"
+	case SensorShorted:
+		return "shorted"
+	case SensorOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// SensorTest holds sensor test results of channels.
+type SensorTest struct {
+	// states by channel number - 1.
+	states [maxChanNum]SensorState
+}
+
+// DecodeInputTest decodes InputTest bitmask for the tested channels.
+// Set bits are open sensors, reset ones are closed (shorted) sensors.
+func DecodeInputTest(mask uint32, chs ...uint) SensorTest {
+	return decodeTest(mask, SensorOpen, SensorShorted, chs)
+}
+
+// DecodeLineTest decodes LineTest bitmask for the tested channels.
+// Line test result isn't documented, set bits are treated as broken (open) lines.
+func DecodeLineTest(mask uint32, chs ...uint) SensorTest {
+	return decodeTest(mask, SensorOpen, SensorOK, chs)
+}
+
+func decodeTest(mask uint32, set, reset SensorState, chs []uint) SensorTest {
+	var t SensorTest
+	for _, ch := range chs {
+		if ch == 0 || ch > maxChanNum {
+			continue
+		}
+		t.states[ch-1] = reset
+		if mask&(1<<(ch-1)) != 0 {
+			t.states[ch-1] = set
+		}
+	}
+	return t
+}
+
+// State returns the test result of the channel.
+func (t SensorTest) State(ch uint) SensorState {
+	if ch == 0 || ch > maxChanNum {
+		return SensorNotTested
+	}
+	return t.states[ch-1]
+}
+
+// Channels returns tested channels in ascending order.
+func (t SensorTest) Channels() []uint {
+	var rv []uint
+	for i, s := range t.states {
+		if s != SensorNotTested {
+			rv = append(rv, uint(i+1))
+		}
+	}
+	return rv
+}
+
+// String lists tested channels states, e.g. "1: ok, 2: open".
+func (t SensorTest) String() string {
+	var b strings.Builder
+	for _, ch := range t.Channels() {
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%d: %v", ch, t.State(ch))
+	}
+	return b.String()
+}
+
+// CheckInputs runs InputTest and decodes its result.
+func (c *Client) CheckInputs(chs ...uint) (SensorTest, error) {
+	return c.CheckInputsContext(context.Background(), chs...)
+}
+
+// CheckInputsContext is like CheckInputs but aborts the request when ctx is done.
+func (c *Client) CheckInputsContext(ctx context.Context, chs ...uint) (SensorTest, error) {
+	mask, err := c.InputTestContext(ctx, chs...)
+	if err != nil {
+		return SensorTest{}, err
+	}
+	return DecodeInputTest(mask, chs...), nil
+}
+
+// CheckLines runs LineTest and decodes its result.
+// See LineTestWarning.
+func (c *Client) CheckLines(chs ...uint) (SensorTest, error) {
+	return c.CheckLinesContext(context.Background(), chs...)
+}
+
+// CheckLinesContext is like CheckLines but aborts the request when ctx is done.
+func (c *Client) CheckLinesContext(ctx context.Context, chs ...uint) (SensorTest, error) {
+	mask, err := c.LineTestContext(ctx, chs...)
+	if err != nil {
+		return SensorTest{}, err
+	}
+	return DecodeLineTest(mask, chs...), nil
+}
+
+// SensorCheck holds results of both sensor tests.
+type SensorCheck struct {
+	// Input states.
+	Inputs SensorTest
+	// Line states.
+	Lines SensorTest
+}
+
+// CheckSensors runs input and line tests of chs channels, all 16 channels by default.
+// See LineTestWarning.
+func (c *Client) CheckSensors(chs ...uint) (*SensorCheck, error) {
+	return c.CheckSensorsContext(context.Background(), chs...)
+}
+
+// CheckSensorsContext is like CheckSensors but aborts the request when ctx is done.
+func (c *Client) CheckSensorsContext(ctx context.Context, chs ...uint) (*SensorCheck, error) {
+	if len(chs) == 0 {
+		for ch := uint(1); ch <= maxChanNum; ch++ {
+			chs = append(chs, ch)
+		}
+	}
+	var rv SensorCheck
+	var err error
+	if rv.Inputs, err = c.CheckInputsContext(ctx, chs...); err != nil {
+		return nil, err
+	}
+	if rv.Lines, err = c.CheckLinesContext(ctx, chs...); err != nil {
+		return nil, err
+	}
+	return &rv, nil
+}
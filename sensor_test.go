@@ -0,0 +1,48 @@
+package pulsar_test
+
+import (
+	"testing"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+	"github.com/srgsf/tvh-pulsar/simulator"
+)
+
+func TestDecodeSensorTest(t *testing.T) {
+	in := pulsar.DecodeInputTest(0x05, 1, 2, 3)
+	if in.String() != "1: open, 2: shorted, 3: open" {
+		t.Errorf("unexpected inputs %v", in)
+	}
+	if in.State(4) != pulsar.SensorNotTested || in.State(0) != pulsar.SensorNotTested || in.State(17) != pulsar.SensorNotTested {
+		t.Error("untested channels are reported")
+	}
+	lines := pulsar.DecodeLineTest(0x8000, 1, 16)
+	if lines.State(1) != pulsar.SensorOK || lines.State(16) != pulsar.SensorOpen {
+		t.Errorf("unexpected lines %v", lines)
+	}
+	if chs := lines.Channels(); len(chs) != 2 || chs[0] != 1 || chs[1] != 16 {
+		t.Errorf("unexpected channels %v", chs)
+	}
+}
+
+func TestCheckSensors(t *testing.T) {
+	d, cl := startDevice(t, 1)
+	d.SetInputs(0x01)
+	d.SetLineFaults(0x0100)
+
+	sc, err := cl.CheckSensors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sc.Inputs.Channels()) != 16 || len(sc.Lines.Channels()) != 16 {
+		t.Fatalf("not all channels are tested %v", sc)
+	}
+	if sc.Inputs.State(1) != pulsar.SensorOpen || sc.Inputs.State(2) != pulsar.SensorShorted {
+		t.Errorf("unexpected inputs %v", sc.Inputs)
+	}
+	if sc.Lines.State(9) != pulsar.SensorOpen || sc.Lines.State(1) != pulsar.SensorOK {
+		t.Errorf("unexpected lines %v", sc.Lines)
+	}
+	if d.Requests(simulator.LineTest) != 1 || d.Requests(simulator.InputTest) != 1 {
+		t.Error("unexpected number of requests")
+	}
+}
@@ -0,0 +1,107 @@
+package pulsar
+
+import (
+	"fmt"
+	"time"
+)
+
+// serialConn is a serial port connection handle.
+type serialConn struct {
+	*tcpConn
+	// minimal line silence interval between frames.
+	silence time.Duration
+	// time of the last line activity.
+	last time.Time
+}
+
+// PrepareWrite waits for the line silence interval and configures frame writing operation.
+func (c *serialConn) PrepareWrite() error {
+	if wait := time.Until(c.last.Add(c.silence)); wait > 0 {
+		if err := c.sleep(wait); err != nil {
+			return err
+		}
+	}
+	return c.tcpConn.PrepareWrite()
+}
+
+// sleeps for d or until bound context is done.
+func (c *serialConn) sleep(d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	if c.ctx == nil {
+		<-t.C
+		return nil
+	}
+	select {
+	case <-t.C:
+		return nil
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
+
+// Flush writes buffered frame to the line.
+func (c *serialConn) Flush() error {
+	err := c.tcpConn.Flush()
+	c.last = time.Now()
+	return err
+}
+
+// Read reads up to len(p) bytes into p.
+func (c *serialConn) Read(p []byte) (int, error) {
+	n, err := c.tcpConn.Read(p)
+	c.last = time.Now()
+	return n, err
+}
+
+// DialSerial opens the serial port device, e.g. /dev/ttyUSB0.
+// Speed is the line speed in bauds, e.g. 9600, cfg is the line configuration.
+func DialSerial(device string, speed uint32, cfg SerialConfig) (Conn, error) {
+	var d Dialer
+	return d.DialSerial(device, speed, cfg)
+}
+
+// DialSerial opens the serial port device, e.g. /dev/ttyUSB0.
+// Speed is the line speed in bauds, e.g. 9600, cfg is the line configuration.
+func (d *Dialer) DialSerial(device string, speed uint32, cfg SerialConfig) (Conn, error) {
+	if d.Redial {
+		return newRedialConn(func() (Conn, error) {
+			return d.dialSerial(device, speed, cfg)
+		})
+	}
+	return d.dialSerial(device, speed, cfg)
+}
+
+// dialSerial opens and configures serial port device.
+func (d *Dialer) dialSerial(device string, speed uint32, cfg SerialConfig) (Conn, error) {
+	if speed == 0 {
+		return nil, fmt.Errorf("serial speed is required")
+	}
+	f, err := openSerial(device, speed, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var to = d.RWTimeOut
+	if to == 0 {
+		to = timeout
+	}
+	silence := d.FrameSilence
+	if silence == 0 {
+		silence = charTime(speed, cfg) * 7 / 2
+	}
+	return &serialConn{
+		tcpConn: newConn(f, d.ProtocolLogger, to),
+		silence: silence,
+	}, nil
+}
+
+// charTime returns transmission time of a single character.
+func charTime(speed uint32, cfg SerialConfig) time.Duration {
+	// start bit and 8 data bits.
+	bits := 9 + cfg.StopBits()
+	if cfg.Parity() != 'N' {
+		bits++
+	}
+	return time.Duration(bits) * time.Second / time.Duration(speed)
+}
@@ -0,0 +1,81 @@
+//go:build linux
+// +build linux
+
+package pulsar
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// supported line speeds.
+var bauds = map[uint32]uint32{
+	1200:   syscall.B1200,
+	2400:   syscall.B2400,
+	4800:   syscall.B4800,
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+}
+
+// openSerial opens serial port device and configures it in raw mode.
+func openSerial(device string, speed uint32, cfg SerialConfig) (*os.File, error) {
+	baud, ok := bauds[speed]
+	if !ok {
+		return nil, fmt.Errorf("unsupported serial speed: %d", speed)
+	}
+	f, err := os.OpenFile(device, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	t := syscall.Termios{
+		Cflag:  baud | syscall.CS8 | syscall.CREAD | syscall.CLOCAL,
+		Ispeed: baud,
+		Ospeed: baud,
+	}
+	if cfg.StopBits() == 2 {
+		t.Cflag |= syscall.CSTOPB
+	}
+	switch cfg.Parity() {
+	case 'E':
+		t.Cflag |= syscall.PARENB
+		t.Iflag |= syscall.INPCK
+	case 'O':
+		t.Cflag |= syscall.PARENB | syscall.PARODD
+		t.Iflag |= syscall.INPCK
+	default:
+		t.Iflag |= syscall.IGNPAR
+	}
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+
+	if err := ioctl(f, syscall.TCSETS, unsafe.Pointer(&t)); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("unable to configure %s: %w", device, err)
+	}
+	return f, nil
+}
+
+// ioctl performs control operation on the file descriptor.
+func ioctl(f *os.File, req uint, arg unsafe.Pointer) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
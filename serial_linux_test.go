@@ -0,0 +1,111 @@
+//go:build linux
+// +build linux
+
+package pulsar
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPty opens pseudo-terminal pair. Returns master side and slave device name.
+func openPty(t *testing.T) (*os.File, string) {
+	m, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pseudo-terminal is not available: %v", err)
+	}
+	var unlock int32
+	if err := ioctl(m, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		_ = m.Close()
+		t.Skipf("pseudo-terminal is not available: %v", err)
+	}
+	var n uint32
+	if err := ioctl(m, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		_ = m.Close()
+		t.Skipf("pseudo-terminal is not available: %v", err)
+	}
+	return m, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestSerialClient(t *testing.T) {
+	m, dev := openPty(t)
+	defer func() { _ = m.Close() }()
+
+	d := Dialer{RWTimeOut: time.Second}
+	conn, err := d.DialSerial(dev, 9600, Serial8E1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	go func() {
+		req := make([]byte, 10)
+		if _, err := m.Read(req); err != nil {
+			return
+		}
+		resp := []byte{0x01, 0x02, 0x03, 0x04, 0x04, 0x10, 0x16, 0x09, 0x08, 0x00, 0x2F, 0x0A, req[6], req[7]}
+		_, _ = m.Write(append(resp, generateCRC(resp)...))
+	}()
+
+	cl, err := NewClient("01020304", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm, err := cl.SysTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tm != time.Date(2022, time.September, 8, 00, 47, 10, 00, time.UTC) {
+		t.Error("response decoding failed")
+	}
+}
+
+func TestSerialReadTimeout(t *testing.T) {
+	m, dev := openPty(t)
+	defer func() { _ = m.Close() }()
+
+	d := Dialer{RWTimeOut: 50 * time.Millisecond}
+	conn, err := d.DialSerial(dev, 19200, Serial8N1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	cl, _ := NewClient("01020304", conn)
+	start := time.Now()
+	if _, err = cl.SysTime(); err == nil {
+		t.Error("read timeout is not honored")
+	}
+	if time.Since(start) > time.Second {
+		t.Error("read timeout is not honored")
+	}
+}
+
+func TestSerialUnsupportedSpeed(t *testing.T) {
+	if _, err := DialSerial("/dev/null", 1000, Serial8N1); err == nil {
+		t.Error("unsupported speed is accepted")
+	}
+}
+
+func TestFrameSilence(t *testing.T) {
+	m, dev := openPty(t)
+	defer func() { _ = m.Close() }()
+
+	silence := 100 * time.Millisecond
+	d := Dialer{FrameSilence: silence}
+	conn, err := d.DialSerial(dev, 9600, Serial8N1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.PrepareWrite()
+	_ = conn.Flush()
+	start := time.Now()
+	_ = conn.PrepareWrite()
+	if time.Since(start) < silence {
+		t.Error("frame silence interval is not honored")
+	}
+}
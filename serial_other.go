@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package pulsar
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// openSerial is not supported on this platform.
+func openSerial(device string, _ uint32, _ SerialConfig) (*os.File, error) {
+	return nil, fmt.Errorf("serial port %s: not supported on %s", device, runtime.GOOS)
+}
@@ -0,0 +1,672 @@
+package simulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+)
+
+// Device is an emulated Pulsar-M registrator. Device is safe for concurrent use.
+type Device struct {
+	mu sync.Mutex
+	// network address.
+	address uint32
+	// model id.
+	model uint16
+	// firmware version.
+	firmware uint16
+	// number of channels.
+	channels uint
+	// current channel values.
+	values []float64
+	// channel pulse weights.
+	weights []float32
+	// daylight saving flag.
+	dst bool
+	// pulse and pause lengths in ms.
+	pulseLength, pauseLength float32
+	// diagnostics flags.
+	diagnostics uint64
+	// serial line speed.
+	speed uint32
+	// serial line configuration.
+	serial pulsar.SerialConfig
+	// device clock offset from the host clock.
+	clock time.Duration
+	// location of device clock, daylight saving is applied according to dst flag.
+	loc *time.Location
+	// input states, set bits are open sensors.
+	inputs uint32
+	// line test result, set bits are faulty lines.
+	lineFaults uint32
+	// archive records by type, channel and time.
+	archives map[pulsar.ArchType]map[uint]map[time.Time]float32
+	// maximum number of records that may be requested at once by archive type.
+	maxPeriod map[pulsar.ArchType]int
+	// number of handled requests by function code.
+	requests map[byte]int
+	// scheduled faults by function code.
+	faults map[byte][]*Fault
+}
+
+// NewDevice creates emulated 16 channels device with the address.
+func NewDevice(address uint32) *Device {
+	d := &Device{
+		address:     address,
+		model:       0x9A00,
+		firmware:    102,
+		channels:    16,
+		pulseLength: 100,
+		pauseLength: 100,
+		speed:       9600,
+		serial:      pulsar.Serial8N1,
+		loc:         time.UTC,
+		archives:    make(map[pulsar.ArchType]map[uint]map[time.Time]float32),
+		maxPeriod:   make(map[pulsar.ArchType]int),
+		requests:    make(map[byte]int),
+		faults:      make(map[byte][]*Fault),
+	}
+	d.values = make([]float64, d.channels)
+	d.weights = make([]float32, d.channels)
+	for i := range d.weights {
+		d.weights[i] = 0.01
+	}
+	return d
+}
+
+// Address returns device network address.
+func (d *Device) Address() uint32 {
+	return d.address
+}
+
+// SetModel sets model id and firmware version reported by device.
+func (d *Device) SetModel(model, firmware uint16) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.model = model
+	d.firmware = firmware
+}
+
+// SetChannels sets number of device channels, 1..16. Channel state is reset.
+func (d *Device) SetChannels(n uint) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if n == 0 || n > 16 {
+		return
+	}
+	d.channels = n
+	d.values = make([]float64, n)
+	d.weights = make([]float32, n)
+	for i := range d.weights {
+		d.weights[i] = 0.01
+	}
+}
+
+// Value returns current value of a channel.
+func (d *Device) Value(ch uint) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.valid(ch) {
+		return 0
+	}
+	return d.values[ch-1]
+}
+
+// SetValue sets current value of a channel.
+func (d *Device) SetValue(ch uint, v float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.valid(ch) {
+		d.values[ch-1] = v
+	}
+}
+
+// PulseWeight returns pulse weight of a channel.
+func (d *Device) PulseWeight(ch uint) float32 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.valid(ch) {
+		return 0
+	}
+	return d.weights[ch-1]
+}
+
+// SetPulseWeight sets pulse weight of a channel.
+func (d *Device) SetPulseWeight(ch uint, w float32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.valid(ch) {
+		d.weights[ch-1] = w
+	}
+}
+
+// Pulse counts n pulses on a channel increasing its value by n pulse weights.
+func (d *Device) Pulse(ch uint, n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.valid(ch) {
+		d.values[ch-1] += float64(n) * float64(d.weights[ch-1])
+	}
+}
+
+// SetDiagnostics sets diagnostics flags reported by device.
+func (d *Device) SetDiagnostics(flags uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.diagnostics = flags
+}
+
+// SetInputs sets sensors state, set bits are open sensors, cleared bits are closed ones.
+func (d *Device) SetInputs(mask uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inputs = mask
+}
+
+// SetLineFaults sets line test result, set bits are faulty lines.
+func (d *Device) SetLineFaults(mask uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lineFaults = mask
+}
+
+// DayLightSaving returns daylight saving flag.
+func (d *Device) DayLightSaving() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dst
+}
+
+// SetDayLightSaving sets daylight saving flag.
+func (d *Device) SetDayLightSaving(v bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dst = v
+}
+
+// Serial returns serial line speed and configuration.
+func (d *Device) Serial() (uint32, pulsar.SerialConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.speed, d.serial
+}
+
+// SetLocation sets location of device clock. Defaults to UTC.
+// Unless daylight saving is enabled the clock stays at standard time of the location.
+func (d *Device) SetLocation(loc *time.Location) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.loc = loc
+}
+
+// Now returns device clock time.
+func (d *Device) Now() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.now()
+}
+
+// SetClock sets device clock.
+func (d *Device) SetClock(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clock = time.Until(t)
+}
+
+// Drift shifts device clock by delta.
+func (d *Device) Drift(delta time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clock += delta
+}
+
+// SetRecord stores archive record of a channel. Record time is truncated to the archive period.
+func (d *Device) SetRecord(typ pulsar.ArchType, ch uint, t time.Time, v float32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setRecord(typ, ch, t, v)
+}
+
+// SetArchive stores consecutive archive records of a channel starting at start time.
+func (d *Device) SetArchive(typ pulsar.ArchType, ch uint, start time.Time, values []float32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t := typ.Truncate(start.In(d.zone()))
+	for _, v := range values {
+		d.setRecord(typ, ch, t, v)
+		t = typ.Advance(t, 1)
+	}
+}
+
+// Snapshot stores current channel values as archive records at time t.
+// Hourly records are stored always, daily ones at midnight, monthly ones at the first day of month midnight.
+func (d *Device) Snapshot(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t = t.In(d.zone())
+	types := []pulsar.ArchType{pulsar.Hourly}
+	if t.Hour() == 0 {
+		types = append(types, pulsar.Daily)
+		if t.Day() == 1 {
+			types = append(types, pulsar.Monthly)
+		}
+	}
+	for _, typ := range types {
+		for i, v := range d.values {
+			d.setRecord(typ, uint(i+1), t, float32(v))
+		}
+	}
+}
+
+// SetMaxPeriod limits number of archive records that can be requested at once.
+// Longer requests are rejected with TooLongPeriod error. Zero means unlimited.
+func (d *Device) SetMaxPeriod(typ pulsar.ArchType, records int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.maxPeriod[typ] = records
+}
+
+// Requests returns number of requests handled with the function code.
+func (d *Device) Requests(fn byte) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.requests[fn]
+}
+
+// stores archive record. Must be called with mu held.
+func (d *Device) setRecord(typ pulsar.ArchType, ch uint, t time.Time, v float32) {
+	chs, ok := d.archives[typ]
+	if !ok {
+		chs = make(map[uint]map[time.Time]float32)
+		d.archives[typ] = chs
+	}
+	recs, ok := chs[ch]
+	if !ok {
+		recs = make(map[time.Time]float32)
+		chs[ch] = recs
+	}
+	recs[typ.Truncate(wallClock(t.In(d.zone())))] = v
+}
+
+// returns device clock time. Must be called with mu held.
+func (d *Device) now() time.Time {
+	return time.Now().Add(d.clock).In(d.zone())
+}
+
+// returns location of device clock. Must be called with mu held.
+func (d *Device) zone() *time.Location {
+	return pulsar.DeviceLocation(d.loc, d.dst)
+}
+
+// reports whether channel number is valid. Must be called with mu held.
+func (d *Device) valid(ch uint) bool {
+	return ch > 0 && ch <= d.channels
+}
+
+// discover returns discovery reply frame.
+func (d *Device) discover() []byte {
+	rv := append([]byte{}, discoveryPrefix...)
+	rv = append(rv, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(rv[4:], d.address)
+	return appendCrc(rv)
+}
+
+// handle processes request frame and returns response frames.
+func (d *Device) handle(req []byte) []reply {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fn := req[4]
+	if fn == fnModel && req[5] == modelLen {
+		d.requests[ModelRequest]++
+		rv := make([]byte, 8)
+		binary.BigEndian.PutUint32(rv, d.address)
+		rv[4], rv[5] = fnModel, modelLen
+		binary.BigEndian.PutUint16(rv[6:], d.model)
+		return d.misbehave(ModelRequest, req, appendCrc(rv))
+	}
+
+	d.requests[fn]++
+	ln := len(req)
+	id := req[ln-4 : ln-2]
+	payload, code := d.execute(fn, req[6:ln-4])
+	if code != 0 {
+		return d.misbehave(fn, req, d.frame(fnError, []byte{byte(code)}, id))
+	}
+	return d.misbehave(fn, req, d.frame(fn, payload, id))
+}
+
+// frame encodes response frame.
+func (d *Device) frame(fn byte, payload []byte, id []byte) []byte {
+	rv := make([]byte, 4, minFrameLen+len(payload))
+	binary.BigEndian.PutUint32(rv, d.address)
+	rv = append(rv, fn, byte(minFrameLen+len(payload)))
+	rv = append(rv, payload...)
+	rv = append(rv, id...)
+	return appendCrc(rv)
+}
+
+// execute runs a function with the request payload. Returns response payload or error code.
+// Must be called with mu held.
+func (d *Device) execute(fn byte, p []byte) ([]byte, pulsar.ErrorCode) {
+	switch fn {
+	case fnReadValues:
+		return d.readValues(p)
+	case fnWriteValue, fnWriteValueStd:
+		return d.writeValue(p)
+	case fnReadSysTime:
+		if len(p) != 0 {
+			return nil, pulsar.InvalidLength
+		}
+		return encodeTime(d.now()), 0
+	case fnWriteSysTime:
+		return d.writeTime(p)
+	case fnReadArchive:
+		return d.readArchive(p)
+	case fnReadPulseWeight:
+		return d.readWeights(p)
+	case fnWritePulseWeight:
+		return d.writeWeight(p)
+	case fnLineTest:
+		return d.maskResult(p, d.lineFaults)
+	case fnInputTest:
+		return d.maskResult(p, d.inputs)
+	case fnReadSettings:
+		return d.readParam(p)
+	case fnWriteSettings:
+		return d.writeParam(p)
+	default:
+		return nil, pulsar.IllegalFunction
+	}
+}
+
+// decodes channel mask and returns channel numbers. Must be called with mu held.
+func (d *Device) channelsOf(p []byte) ([]uint, pulsar.ErrorCode) {
+	if len(p) < 4 {
+		return nil, pulsar.InvalidLength
+	}
+	mask := binary.LittleEndian.Uint32(p)
+	if mask == 0 || mask>>d.channels != 0 {
+		return nil, pulsar.InvalidBitMask
+	}
+	var rv []uint
+	for ch := uint(1); ch <= d.channels; ch++ {
+		if mask&(1<<(ch-1)) != 0 {
+			rv = append(rv, ch)
+		}
+	}
+	return rv, 0
+}
+
+// decodes a single channel mask. Must be called with mu held.
+func (d *Device) channelOf(p []byte) (uint, pulsar.ErrorCode) {
+	chs, code := d.channelsOf(p)
+	if code != 0 {
+		return 0, code
+	}
+	if len(chs) != 1 {
+		return 0, pulsar.InvalidBitMask
+	}
+	return chs[0], 0
+}
+
+func (d *Device) readValues(p []byte) ([]byte, pulsar.ErrorCode) {
+	if len(p) != 4 {
+		return nil, pulsar.InvalidLength
+	}
+	chs, code := d.channelsOf(p)
+	if code != 0 {
+		return nil, code
+	}
+	var b bytes.Buffer
+	for _, ch := range chs {
+		_ = binary.Write(&b, binary.LittleEndian, d.values[ch-1])
+	}
+	return b.Bytes(), 0
+}
+
+func (d *Device) writeValue(p []byte) ([]byte, pulsar.ErrorCode) {
+	if len(p) != 12 {
+		return nil, pulsar.InvalidLength
+	}
+	ch, code := d.channelOf(p)
+	if code != 0 {
+		return nil, code
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(p[4:]))
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, pulsar.InvalidParamValue
+	}
+	d.values[ch-1] = v
+	return p[:4], 0
+}
+
+func (d *Device) readWeights(p []byte) ([]byte, pulsar.ErrorCode) {
+	if len(p) != 4 {
+		return nil, pulsar.InvalidLength
+	}
+	chs, code := d.channelsOf(p)
+	if code != 0 {
+		return nil, code
+	}
+	var b bytes.Buffer
+	for _, ch := range chs {
+		_ = binary.Write(&b, binary.LittleEndian, d.weights[ch-1])
+	}
+	return b.Bytes(), 0
+}
+
+func (d *Device) writeWeight(p []byte) ([]byte, pulsar.ErrorCode) {
+	if len(p) != 8 {
+		return nil, pulsar.InvalidLength
+	}
+	ch, code := d.channelOf(p)
+	if code != 0 {
+		return nil, code
+	}
+	w := math.Float32frombits(binary.LittleEndian.Uint32(p[4:]))
+	if !(w > 0) || math.IsInf(float64(w), 0) {
+		return nil, pulsar.InvalidParamValue
+	}
+	d.weights[ch-1] = w
+	return p[:4], 0
+}
+
+func (d *Device) writeTime(p []byte) ([]byte, pulsar.ErrorCode) {
+	if len(p) != 6 {
+		return nil, pulsar.InvalidLength
+	}
+	t, ok := decodeTime(p, d.zone())
+	if !ok {
+		return nil, pulsar.InvalidParamValue
+	}
+	d.clock = time.Until(t)
+	return []byte{0x01, 0x00, 0x00, 0x00}, 0
+}
+
+func (d *Device) maskResult(p []byte, result uint32) ([]byte, pulsar.ErrorCode) {
+	if len(p) != 4 {
+		return nil, pulsar.InvalidLength
+	}
+	if _, code := d.channelsOf(p); code != 0 {
+		return nil, code
+	}
+	rv := make([]byte, 4)
+	binary.LittleEndian.PutUint32(rv, result&binary.LittleEndian.Uint32(p))
+	return rv, 0
+}
+
+func (d *Device) readParam(p []byte) ([]byte, pulsar.ErrorCode) {
+	if len(p) != 2 {
+		return nil, pulsar.InvalidLength
+	}
+	rv := make([]byte, 8)
+	switch binary.LittleEndian.Uint16(p) {
+	case paramDayLightSaving:
+		if d.dst {
+			rv[0] = 1
+		}
+	case paramPulseLength:
+		binary.LittleEndian.PutUint32(rv, math.Float32bits(d.pulseLength))
+	case paramPauseLength:
+		binary.LittleEndian.PutUint32(rv, math.Float32bits(d.pauseLength))
+	case paramFirmware:
+		binary.LittleEndian.PutUint16(rv, d.firmware)
+	case paramDiagnostics:
+		binary.LittleEndian.PutUint64(rv, d.diagnostics)
+	case paramSpeed:
+		binary.LittleEndian.PutUint32(rv, d.speed)
+	case paramSerial:
+		rv[0] = byte(d.serial)
+	default:
+		return nil, pulsar.MissingParam
+	}
+	return rv, 0
+}
+
+// supported serial line speeds.
+var speeds = map[uint32]bool{1200: true, 2400: true, 4800: true, 9600: true, 19200: true}
+
+// supported serial line configurations.
+var serials = map[pulsar.SerialConfig]bool{
+	pulsar.Serial8N1: true, pulsar.Serial8N2: true,
+	pulsar.Serial8O1: true, pulsar.Serial8O2: true,
+	pulsar.Serial8E1: true, pulsar.Serial8E2: true,
+}
+
+func (d *Device) writeParam(p []byte) ([]byte, pulsar.ErrorCode) {
+	if len(p) != 10 {
+		return nil, pulsar.InvalidLength
+	}
+	v := p[2:]
+	switch binary.LittleEndian.Uint16(p) {
+	case paramDayLightSaving:
+		switch binary.LittleEndian.Uint16(v) {
+		case 0:
+			d.dst = false
+		case 1:
+			d.dst = true
+		default:
+			return nil, pulsar.InvalidParamValue
+		}
+	case paramPulseLength, paramPauseLength:
+		l := math.Float32frombits(binary.LittleEndian.Uint32(v))
+		if !(l >= 10 && l <= 1999) {
+			// pulsar.Client encodes lengths as float64.
+			l = float32(math.Float64frombits(binary.LittleEndian.Uint64(v)))
+		}
+		if !(l >= 10 && l <= 1999) {
+			return nil, pulsar.InvalidParamValue
+		}
+		if binary.LittleEndian.Uint16(p) == paramPulseLength {
+			d.pulseLength = l
+		} else {
+			d.pauseLength = l
+		}
+	case paramSpeed:
+		s := binary.LittleEndian.Uint32(v)
+		if !speeds[s] {
+			return nil, pulsar.InvalidParamValue
+		}
+		d.speed = s
+	case paramSerial:
+		s := pulsar.SerialConfig(v[0])
+		if !serials[s] {
+			return nil, pulsar.InvalidParamValue
+		}
+		d.serial = s
+	case paramFirmware, paramDiagnostics:
+		return nil, pulsar.IllegalAccess
+	default:
+		return nil, pulsar.MissingParam
+	}
+	return []byte{0x00, 0x00}, 0
+}
+
+// maximum number of archive values in a single response frame.
+const maxArchiveValues = (maxFrameLen - minFrameLen - 10) / 4
+
+func (d *Device) readArchive(p []byte) ([]byte, pulsar.ErrorCode) {
+	if len(p) != 18 {
+		return nil, pulsar.InvalidLength
+	}
+	ch, code := d.channelOf(p)
+	if code != 0 {
+		return nil, code
+	}
+	typ := pulsar.ArchType(binary.LittleEndian.Uint16(p[4:]))
+	if typ < pulsar.Hourly || typ > pulsar.Monthly {
+		return nil, pulsar.MissingArchive
+	}
+	// archive records are kept by wall clock.
+	start, ok := decodeTime(p[6:12], time.UTC)
+	if !ok {
+		return nil, pulsar.InvalidParamValue
+	}
+	end, ok := decodeTime(p[12:18], time.UTC)
+	if !ok || end.Before(start) {
+		return nil, pulsar.InvalidParamValue
+	}
+	start = typ.Truncate(start)
+
+	var n int
+	for t := start; !t.After(end); t = typ.Advance(t, 1) {
+		n++
+	}
+	if max := d.maxPeriod[typ]; max > 0 && n > max {
+		return nil, pulsar.TooLongPeriod
+	}
+	if n > maxArchiveValues {
+		n = maxArchiveValues
+	}
+
+	recs := d.archives[typ][ch]
+	var b bytes.Buffer
+	_, _ = b.Write(p[:4])
+	_, _ = b.Write(encodeTime(start))
+	var found bool
+	t := start
+	for i := 0; i < n; i++ {
+		v, ok := recs[t]
+		if ok {
+			found = true
+			_ = binary.Write(&b, binary.LittleEndian, v)
+		} else {
+			_ = binary.Write(&b, binary.LittleEndian, noData)
+		}
+		t = typ.Advance(t, 1)
+	}
+	if !found {
+		return nil, pulsar.MissingArchive
+	}
+	return b.Bytes(), 0
+}
+
+// encodes time as [year from 2000, month, day, hour, minute, second].
+func encodeTime(t time.Time) []byte {
+	return []byte{
+		byte(t.Year() - 2000),
+		byte(t.Month()),
+		byte(t.Day()),
+		byte(t.Hour()),
+		byte(t.Minute()),
+		byte(t.Second()),
+	}
+}
+
+// decodes time in the location. Reports whether encoded time is valid.
+func decodeTime(p []byte, loc *time.Location) (time.Time, bool) {
+	if p[0] > 99 || p[1] < 1 || p[1] > 12 || p[2] < 1 || p[2] > 31 || p[3] > 23 || p[4] > 59 || p[5] > 59 {
+		return time.Time{}, false
+	}
+	return time.Date(2000+int(p[0]), time.Month(p[1]), int(p[2]), int(p[3]), int(p[4]), int(p[5]), 0, loc), true
+}
+
+// returns wall clock of t in UTC.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
@@ -0,0 +1,157 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+)
+
+// Function codes of requests faults can be bound to.
+const (
+	// AnyFunction matches requests with any function code.
+	AnyFunction byte = 0x00
+	// ModelRequest matches model requests.
+	ModelRequest byte = 0xFF
+
+	ReadValues       = fnReadValues
+	WriteValue       = fnWriteValue
+	ReadSysTime      = fnReadSysTime
+	WriteSysTime     = fnWriteSysTime
+	ReadArchive      = fnReadArchive
+	ReadPulseWeight  = fnReadPulseWeight
+	WritePulseWeight = fnWritePulseWeight
+	LineTest         = fnLineTest
+	ReadSettings     = fnReadSettings
+	WriteSettings    = fnWriteSettings
+	InputTest        = fnInputTest
+)
+
+// FaultKind is a kind of device misbehaviour.
+type FaultKind int
+
+const (
+	// Drop suppresses the reply.
+	Drop FaultKind = iota + 1
+	// Delay postpones the reply by Fault.Delay.
+	Delay
+	// CorruptCRC replies with invalid checksum.
+	CorruptCRC
+	// Truncate cuts the reply to Fault.Length bytes, half of the frame by default.
+	Truncate
+	// WrongFunction replies with a function code other than requested one.
+	WrongFunction
+	// WrongID replies with Fault.ID message id, request id with inverted high bit by default.
+	WrongID
+	// ForeignAddress sends a copy of the reply on behalf of Fault.Address device before the genuine reply.
+	ForeignAddress
+	// ErrorReply replies with Fault.Code error.
+	ErrorReply
+)
+
+// Fault describes a device misbehaviour.
+type Fault struct {
+	// Kind of misbehaviour.
+	Kind FaultKind
+	// Reply delay for Delay fault.
+	Delay time.Duration
+	// Length of a truncated reply for Truncate fault.
+	Length int
+	// Error code for ErrorReply fault.
+	Code pulsar.ErrorCode
+	// Message id for WrongID fault.
+	ID uint16
+	// Address of a foreign device for ForeignAddress fault.
+	Address uint32
+	// Number of matching requests to pass through before the fault applies.
+	Skip int
+	// Number of requests the fault applies to. Zero means every matching request until faults are cleared.
+	Count int
+}
+
+// InjectFaults schedules faults for requests with the function code.
+// Faults are applied in order: the next fault takes effect once the previous one is exhausted.
+func (d *Device) InjectFaults(fn byte, faults ...Fault) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range faults {
+		f := faults[i]
+		d.faults[fn] = append(d.faults[fn], &f)
+	}
+}
+
+// ClearFaults removes all scheduled faults.
+func (d *Device) ClearFaults() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.faults = make(map[byte][]*Fault)
+}
+
+// nextFault returns the fault to apply to a request with the function code or nil.
+// Function specific faults take precedence. Must be called with mu held.
+func (d *Device) nextFault(fn byte) *Fault {
+	for _, key := range []byte{fn, AnyFunction} {
+		faults := d.faults[key]
+		if len(faults) == 0 {
+			continue
+		}
+		f := faults[0]
+		if f.Skip > 0 {
+			f.Skip--
+			return nil
+		}
+		if f.Count > 0 {
+			f.Count--
+			if f.Count == 0 {
+				d.faults[key] = faults[1:]
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+// misbehave applies scheduled fault to the reply. Must be called with mu held.
+func (d *Device) misbehave(fn byte, req, resp []byte) []reply {
+	f := d.nextFault(fn)
+	if f == nil {
+		return []reply{{frame: resp}}
+	}
+
+	ln := len(resp)
+	switch f.Kind {
+	case Drop:
+		return nil
+	case Delay:
+		return []reply{{frame: resp, delay: f.Delay}}
+	case CorruptCRC:
+		resp[ln-1] ^= 0xFF
+	case Truncate:
+		n := f.Length
+		if n <= 0 || n >= ln {
+			n = ln / 2
+		}
+		resp = resp[:n]
+	case WrongFunction:
+		resp[4] ^= 0x80
+		resp = appendCrc(resp[:ln-2])
+	case WrongID:
+		if fn == ModelRequest {
+			break
+		}
+		id := f.ID
+		if id == 0 {
+			rl := len(req)
+			id = binary.BigEndian.Uint16(req[rl-4:rl-2]) ^ 0x8000
+		}
+		binary.BigEndian.PutUint16(resp[ln-4:], id)
+		resp = appendCrc(resp[:ln-2])
+	case ForeignAddress:
+		foreign := append([]byte{}, resp[:ln-2]...)
+		binary.BigEndian.PutUint32(foreign, f.Address)
+		return []reply{{frame: appendCrc(foreign)}, {frame: resp}}
+	case ErrorReply:
+		resp = d.frame(fnError, []byte{byte(f.Code)}, resp[ln-4:ln-2])
+	}
+	return []reply{{frame: resp}}
+}
@@ -0,0 +1,150 @@
+package simulator_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+	"github.com/srgsf/tvh-pulsar/simulator"
+)
+
+func TestFaultDrop(t *testing.T) {
+	s, conn := startSimulator(t, 1)
+	s.Device(1).InjectFaults(simulator.ReadSysTime, simulator.Fault{Kind: simulator.Drop, Count: 1})
+	cl, _ := pulsar.NewClient("00000001", conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := cl.SysTimeContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if _, err := cl.SysTime(); err != nil {
+		t.Errorf("fault isn't exhausted: %v", err)
+	}
+}
+
+func TestFaultDelay(t *testing.T) {
+	s, _ := startSimulator(t, 1)
+	s.Device(1).InjectFaults(simulator.AnyFunction, simulator.Fault{Kind: simulator.Delay, Delay: 200 * time.Millisecond})
+	d := pulsar.Dialer{RWTimeOut: 50 * time.Millisecond}
+	conn, err := d.DialTCP(s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	cl, _ := pulsar.NewClient("00000001", conn)
+	_, err = cl.FirmwareVersion()
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("expected timeout, got %v", err)
+	}
+}
+
+func TestFaultCorruptCRCRetry(t *testing.T) {
+	s, conn := startSimulator(t, 1)
+	s.Device(1).InjectFaults(simulator.ReadValues,
+		simulator.Fault{Kind: simulator.CorruptCRC, Count: 1},
+		simulator.Fault{Kind: simulator.WrongFunction, Count: 1},
+	)
+	cl, _ := pulsar.NewClient("00000001", conn)
+	if _, err := cl.CurValues(1); err != pulsar.ErrCRC {
+		t.Errorf("expected %v, got %v", pulsar.ErrCRC, err)
+	}
+	if _, err := cl.CurValues(1); err == nil {
+		t.Error("wrong function isn't detected")
+	}
+
+	s.Device(1).InjectFaults(simulator.ReadValues, simulator.Fault{Kind: simulator.CorruptCRC, Count: 2})
+	cl, _ = pulsar.NewClient("00000001", conn, pulsar.WithRetry(pulsar.RetryPolicy{MaxAttempts: 3}))
+	if _, err := cl.CurValues(1); err != nil {
+		t.Errorf("request isn't retried: %v", err)
+	}
+	if n := s.Device(1).Requests(simulator.ReadValues); n != 5 {
+		t.Errorf("expected 5 requests, got %d", n)
+	}
+}
+
+func TestFaultSkip(t *testing.T) {
+	s, conn := startSimulator(t, 1)
+	s.Device(1).InjectFaults(simulator.AnyFunction,
+		simulator.Fault{Kind: simulator.ErrorReply, Code: pulsar.IllegalAccess, Skip: 1, Count: 1})
+	cl, _ := pulsar.NewClient("00000001", conn)
+	if _, err := cl.FirmwareVersion(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := cl.FirmwareVersion()
+	checkCode(t, err, pulsar.IllegalAccess)
+	if _, err := cl.FirmwareVersion(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFaultForeignAddress(t *testing.T) {
+	s, conn := startSimulator(t, 1)
+	s.Device(1).InjectFaults(simulator.ReadSettings, simulator.Fault{Kind: simulator.ForeignAddress, Address: 7})
+	b := pulsar.NewBus(conn)
+	cl, _ := b.Client("00000001")
+	if _, err := cl.FirmwareVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Stats()[7].Stray != 1 {
+		t.Error("foreign reply isn't accounted")
+	}
+}
+
+func TestFaultTruncate(t *testing.T) {
+	s, conn := startSimulator(t, 1)
+	s.Device(1).InjectFaults(simulator.ModelRequest, simulator.Fault{Kind: simulator.Truncate, Count: 1})
+	cl, _ := pulsar.NewClient("00000001", conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := cl.ModelContext(ctx); err == nil {
+		t.Error("truncated reply is accepted")
+	}
+	s.Device(1).ClearFaults()
+}
+
+func TestFaultWrongID(t *testing.T) {
+	s, conn := startSimulator(t, 1)
+	s.Device(1).InjectFaults(simulator.ReadSysTime, simulator.Fault{Kind: simulator.WrongID, Count: 1})
+	cl, _ := pulsar.NewClient("00000001", conn)
+	_, err := cl.SysTime()
+	var ie *pulsar.IdMismatchError
+	if !errors.As(err, &ie) {
+		t.Fatalf("expected id mismatch, got %v", err)
+	}
+	if ie.Got != ie.Expected^0x8000 {
+		t.Errorf("unexpected ids %v", ie)
+	}
+}
+
+func TestLateReplyDiscarded(t *testing.T) {
+	s, conn := startSimulator(t, 1)
+	d := s.Device(1)
+	d.SetValue(1, 1)
+	d.SetValue(2, 2)
+	d.InjectFaults(simulator.ReadValues, simulator.Fault{Kind: simulator.Delay, Delay: 150 * time.Millisecond, Count: 1})
+	b := pulsar.NewBus(conn)
+	cl, _ := b.Client("00000001")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := cl.CurValuesContext(ctx, 1); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	v, err := cl.CurValues(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 1 || v[0].Value != 2 {
+		t.Errorf("late reply is accepted: %v", v)
+	}
+	if cl.StaleReplies() != 1 {
+		t.Errorf("expected 1 stale reply, got %d", cl.StaleReplies())
+	}
+	if st := b.Stats()[1]; st.Stale != 1 {
+		t.Errorf("expected 1 stale reply in stats, got %d", st.Stale)
+	}
+}
@@ -0,0 +1,83 @@
+package simulator
+
+import "encoding/binary"
+
+// minimal frame length.
+const minFrameLen = 10
+
+// maximum frame length.
+const maxFrameLen = 255
+
+// broadcast device address.
+const broadcast uint32 = 0
+
+// Function codes.
+const (
+	fnError            byte = 0x00
+	fnReadValues       byte = 0x01
+	fnWriteValueStd    byte = 0x02
+	fnWriteValue       byte = 0x03
+	fnReadSysTime      byte = 0x04
+	fnWriteSysTime     byte = 0x05
+	fnReadArchive      byte = 0x06
+	fnReadPulseWeight  byte = 0x07
+	fnWritePulseWeight byte = 0x08
+	fnLineTest         byte = 0x09
+	fnReadSettings     byte = 0x0A
+	fnWriteSettings    byte = 0x0B
+	fnInputTest        byte = 0x19
+)
+
+// model request is recognized by function and length bytes.
+const (
+	fnModel  byte = 0x03
+	modelLen byte = 0x02
+)
+
+// discovery request prefix, the same prefix is used in discovery replies.
+var discoveryPrefix = []byte{0xF0, 0x0F, 0x0F, 0xF0}
+
+// configuration param indexes.
+const (
+	paramDayLightSaving uint16 = 0x0001
+	paramPulseLength    uint16 = 0x0003
+	paramPauseLength    uint16 = 0x0004
+	paramFirmware       uint16 = 0x0005
+	paramDiagnostics    uint16 = 0x0006
+	paramSpeed          uint16 = 0x0008
+	paramSerial         uint16 = 0x0009
+)
+
+// archive value that means there is no data.
+const noData uint32 = 0xFFFFFFF0
+
+// crc16 of data.
+func crc(data []byte) uint16 {
+	var c uint16 = 0xffff
+	for _, b := range data {
+		c ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if c&1 > 0 {
+				c = (c >> 1) ^ 0xA001
+			} else {
+				c >>= 1
+			}
+		}
+	}
+	return c
+}
+
+// validCrc checks frame checksum.
+func validCrc(frame []byte) bool {
+	ln := len(frame) - 2
+	if ln <= 0 {
+		return false
+	}
+	return crc(frame[:ln]) == binary.LittleEndian.Uint16(frame[ln:])
+}
+
+// appendCrc appends checksum to the frame.
+func appendCrc(frame []byte) []byte {
+	c := crc(frame)
+	return append(frame, byte(c), byte(c>>8))
+}
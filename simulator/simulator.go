@@ -0,0 +1,218 @@
+// Package simulator emulates Pulsar-M pulse registrators speaking pulsar data transmission protocol over TCP.
+//
+// It is intended for integration tests of software built on top of the pulsar client
+// when real hardware isn't available.
+package simulator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Simulator serves one or more emulated devices on a TCP socket, as if they were connected
+// to the same rs485 to Ethernet converter.
+type Simulator struct {
+	mu sync.Mutex
+	// emulated devices by address.
+	devices map[uint32]*Device
+	// listener accepting connections.
+	l net.Listener
+	// active connections.
+	conns map[net.Conn]struct{}
+	// tracks serving goroutines.
+	wg sync.WaitGroup
+}
+
+// New creates a simulator without devices.
+func New() *Simulator {
+	return &Simulator{
+		devices: make(map[uint32]*Device),
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+// AddDevice adds emulated device with the address. Existing device is returned if the address is taken.
+func (s *Simulator) AddDevice(address uint32) *Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[address]; ok {
+		return d
+	}
+	d := NewDevice(address)
+	s.devices[address] = d
+	return d
+}
+
+// Device returns emulated device by address or nil if there is no such device.
+func (s *Simulator) Device(address uint32) *Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.devices[address]
+}
+
+// Devices returns emulated devices ordered by address.
+func (s *Simulator) Devices() []*Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rv := make([]*Device, 0, len(s.devices))
+	for _, d := range s.devices {
+		rv = append(rv, d)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].address < rv[j].address })
+	return rv
+}
+
+// Listen starts serving devices on the tcp socket in background, e.g. "127.0.0.1:0".
+func (s *Simulator) Listen(socket string) error {
+	l, err := net.Listen("tcp", socket)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.l = l
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		_ = s.Serve(l)
+	}()
+	return nil
+}
+
+// Addr returns listening socket address or nil if simulator isn't listening.
+func (s *Simulator) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.l == nil {
+		return nil
+	}
+	return s.l.Addr()
+}
+
+// Serve accepts connections on the listener and serves devices on each of them.
+// It blocks until listener fails or simulator is closed.
+func (s *Simulator) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.l = l
+	s.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn serves devices on the connection until it is closed.
+func (s *Simulator) ServeConn(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		req, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		for _, resp := range s.handle(req) {
+			if resp.delay > 0 {
+				time.Sleep(resp.delay)
+			}
+			if _, err := conn.Write(resp.frame); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close stops listening, closes active connections and waits for serving goroutines to complete.
+func (s *Simulator) Close() error {
+	s.mu.Lock()
+	var err error
+	if s.l != nil {
+		err = s.l.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// reply is a response frame to be sent after the delay.
+type reply struct {
+	frame []byte
+	delay time.Duration
+}
+
+// handle dispatches request frame to devices. Returns response frames.
+func (s *Simulator) handle(req []byte) []reply {
+	if !validCrc(req) {
+		// devices ignore corrupted frames.
+		return nil
+	}
+	if bytes.HasPrefix(req, discoveryPrefix) {
+		var rv []reply
+		for _, d := range s.Devices() {
+			rv = append(rv, reply{frame: d.discover()})
+		}
+		return rv
+	}
+
+	addr := binary.BigEndian.Uint32(req)
+	d := s.Device(addr)
+	if d == nil && addr == broadcast {
+		if devs := s.Devices(); len(devs) == 1 {
+			d = devs[0]
+		}
+	}
+	if d == nil {
+		return nil
+	}
+	return d.handle(req)
+}
+
+// readFrame reads a single request frame.
+// Discovery and model requests don't follow the common frame format and are recognized by their content.
+func readFrame(r io.Reader) ([]byte, error) {
+	head := make([]byte, 6)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	var n int
+	switch {
+	case bytes.HasPrefix(head, discoveryPrefix),
+		head[4] == fnModel && head[5] == modelLen:
+		n = 11
+	default:
+		n = int(head[5])
+		if n < minFrameLen {
+			// garbage, resync on the next byte is not possible without length, drop the head.
+			return head, nil
+		}
+	}
+	frame := append(head, make([]byte, n-len(head))...)
+	if _, err := io.ReadFull(r, frame[len(head):]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
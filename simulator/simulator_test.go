@@ -0,0 +1,210 @@
+package simulator_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+	"github.com/srgsf/tvh-pulsar/simulator"
+)
+
+func startSimulator(t *testing.T, addrs ...uint32) (*simulator.Simulator, pulsar.Conn) {
+	s := simulator.New()
+	for _, a := range addrs {
+		s.AddDevice(a)
+	}
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Skipf("tcp is not available: %v", err)
+	}
+	for s.Addr() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	d := pulsar.Dialer{RWTimeOut: time.Second}
+	conn, err := d.DialTCP(s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		_ = s.Close()
+	})
+	return s, conn
+}
+
+func checkCode(t *testing.T, err error, code pulsar.ErrorCode) {
+	t.Helper()
+	var pe *pulsar.ProtocolError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected protocol error, got %v", err)
+	}
+	if pe.Code() != code {
+		t.Errorf("expected error code %d, got %d", code, pe.Code())
+	}
+}
+
+func TestDiscoverAndModel(t *testing.T) {
+	_, conn := startSimulator(t, 0x12345678)
+	cl, err := pulsar.Discover(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.Address() != 0x12345678 {
+		t.Errorf("wrong address discovered: %x", cl.Address())
+	}
+	m, err := cl.Model()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != 0x9A00 {
+		t.Errorf("wrong model: %x", m)
+	}
+}
+
+func TestScan(t *testing.T) {
+	_, conn := startSimulator(t, 1, 2, 3)
+	devs, err := pulsar.Scan(context.Background(), conn, pulsar.ScanOptions{Window: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devs) != 3 {
+		t.Errorf("expected 3 devices, got %+v", devs)
+	}
+}
+
+func TestValues(t *testing.T) {
+	s, conn := startSimulator(t, 1)
+	cl, _ := pulsar.NewClient("00000001", conn)
+	dev := s.Device(1)
+	dev.SetValue(2, 10)
+	dev.Pulse(2, 150)
+
+	if err := cl.SetCurValue(1, 690.87); err != nil {
+		t.Fatal(err)
+	}
+	vals, err := cl.CurValues(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vals[0].Value != 690.87 || fmt.Sprintf("%.2f", vals[1].Value) != "11.50" {
+		t.Errorf("wrong values: %+v", vals)
+	}
+
+	if err := cl.SetPulseWeight(3, 0.1); err != nil {
+		t.Fatal(err)
+	}
+	w, err := cl.PulseWeight(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w[0].Value != 0.1 || dev.PulseWeight(3) != 0.1 {
+		t.Errorf("wrong pulse weight: %+v", w)
+	}
+}
+
+func TestClock(t *testing.T) {
+	s, conn := startSimulator(t, 1)
+	cl, _ := pulsar.NewClient("00000001", conn)
+	tm := time.Date(2022, time.September, 8, 0, 47, 10, 0, time.UTC)
+	if err := cl.SetSysTime(tm); err != nil {
+		t.Fatal(err)
+	}
+	if d := s.Device(1).Now().Sub(tm); d < 0 || d > time.Second {
+		t.Errorf("device clock isn't set: %v", s.Device(1).Now())
+	}
+	st, err := cl.SysTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := st.Sub(tm); d < 0 || d > 2*time.Second {
+		t.Errorf("wrong device time: %v", st)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	s, conn := startSimulator(t, 1)
+	cl, _ := pulsar.NewClient("00000001", conn)
+	if err := cl.SetSerialSpeed(19200); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.SetSerialConfig(pulsar.Serial8E1); err != nil {
+		t.Fatal(err)
+	}
+	speed, cfg := s.Device(1).Serial()
+	if speed != 19200 || cfg != pulsar.Serial8E1 {
+		t.Errorf("serial settings aren't written: %d %s", speed, cfg)
+	}
+	if sp, err := cl.SerialSpeed(); err != nil || sp != 19200 {
+		t.Errorf("wrong serial speed: %d, %v", sp, err)
+	}
+	if fw, err := cl.FirmwareVersion(); err != nil || fw != 102 {
+		t.Errorf("wrong firmware: %d, %v", fw, err)
+	}
+	if pl, err := cl.PulseLength(); err != nil || pl != 100 {
+		t.Errorf("wrong pulse length: %f, %v", pl, err)
+	}
+	checkCode(t, cl.SetSerialSpeed(1000), pulsar.InvalidParamValue)
+}
+
+func TestArchive(t *testing.T) {
+	s, conn := startSimulator(t, 1)
+	cl, _ := pulsar.NewClient("00000001", conn)
+	start := time.Date(2022, time.September, 6, 14, 0, 0, 0, time.UTC)
+	s.Device(1).SetArchive(pulsar.Hourly, 1, start, []float32{1, 2, 3, 4})
+
+	l, err := cl.HourlyLog(1, start, start.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Start.Equal(start) || len(l.Values) != 4 || l.Values[3] != 4 {
+		t.Errorf("wrong archive: %+v", l)
+	}
+
+	_, err = cl.DailyLog(1, start, start)
+	checkCode(t, err, pulsar.MissingArchive)
+
+	s.Device(1).SetMaxPeriod(pulsar.Hourly, 2)
+	_, err = cl.HourlyLog(1, start, start.Add(3*time.Hour))
+	checkCode(t, err, pulsar.TooLongPeriod)
+}
+
+func TestTests(t *testing.T) {
+	s, conn := startSimulator(t, 1)
+	cl, _ := pulsar.NewClient("00000001", conn)
+	s.Device(1).SetInputs(0x05)
+	s.Device(1).SetLineFaults(0x02)
+	in, err := cl.InputTest(1, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in != 0x05 {
+		t.Errorf("wrong input test result: %b", in)
+	}
+	lt, err := cl.LineTest(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lt != 0 {
+		t.Errorf("wrong line test result: %b", lt)
+	}
+}
+
+func TestInvalidBitMask(t *testing.T) {
+	s, conn := startSimulator(t, 1)
+	s.Device(1).SetChannels(10)
+	cl, _ := pulsar.NewClient("00000001", conn)
+	_, err := cl.CurValues(12)
+	checkCode(t, err, pulsar.InvalidBitMask)
+}
+
+func TestUnknownDevice(t *testing.T) {
+	_, conn := startSimulator(t, 1)
+	cl, _ := pulsar.NewClient("00000002", conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := cl.SysTimeContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+)
+
+// Segment files keep points of a UTC day in records of recordLen bytes:
+//
+//	[time ns LE8][address LE4][channel][type][value float64 LE8][crc32 LE4]
+//
+// Records are appended in arbitrary time order.
+// Partially written and corrupted records are skipped on reading.
+const (
+	recordLen  = 8 + 4 + 1 + 1 + 8 + 4
+	segmentExt = ".seg"
+	dayLayout  = "20060102"
+)
+
+// segment is a segment file of a day.
+type segment struct {
+	path string
+	// start of the day in UTC.
+	day time.Time
+}
+
+// segmentDay returns the start of the UTC day of t.
+func segmentDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// segmentPath returns the path of day segment in dir.
+func segmentPath(dir string, day time.Time) string {
+	return filepath.Join(dir, day.Format(dayLayout)+segmentExt)
+}
+
+// segments returns segments of dir sorted by day.
+func segments(dir string) ([]segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var rv []segment
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		day, err := time.Parse(dayLayout, strings.TrimSuffix(name, segmentExt))
+		if err != nil {
+			continue
+		}
+		rv = append(rv, segment{path: filepath.Join(dir, name), day: day})
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].day.Before(rv[j].day) })
+	return rv, nil
+}
+
+// appendSegment appends points to the segment file.
+// A partially written record left by a crash is truncated first.
+func appendSegment(path string, points []Point) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	end := fi.Size() - fi.Size()%recordLen
+	if end != fi.Size() {
+		if err := f.Truncate(end); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := writePoints(f, points); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeSegment replaces the segment file with points.
+func writeSegment(path string, points []Point) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if err := writePoints(f, points); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// writePoints writes encoded points to w.
+func writePoints(w io.Writer, points []Point) error {
+	bw := bufio.NewWriter(w)
+	var rec [recordLen]byte
+	for _, p := range points {
+		encode(rec[:], p)
+		if _, err := bw.Write(rec[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// pointKey identifies a point of a series.
+type pointKey struct {
+	address uint32
+	channel uint
+	typ     pulsar.ArchType
+	time    int64
+}
+
+// readSegment returns points of the segment file accepted by filter sorted by time.
+// Points stored more than once, e.g. by a resumed backfill, are returned once with the last written value.
+func readSegment(path string, filter func(Point) bool) ([]Point, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var rv []Point
+	// index of the point by series and time, a record written later replaces the earlier one.
+	index := make(map[pointKey]int)
+	var rec [recordLen]byte
+	for {
+		if _, err := io.ReadFull(r, rec[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, err
+		}
+		p, ok := decode(rec[:])
+		if !ok || filter != nil && !filter(p) {
+			continue
+		}
+		k := pointKey{p.Address, p.Channel, p.Type, p.Time.UnixNano()}
+		if i, ok := index[k]; ok {
+			rv[i] = p
+			continue
+		}
+		index[k] = len(rv)
+		rv = append(rv, p)
+	}
+	sort.SliceStable(rv, func(i, j int) bool { return rv[i].Time.Before(rv[j].Time) })
+	return rv, nil
+}
+
+// encode writes the point record to b.
+func encode(b []byte, p Point) {
+	binary.LittleEndian.PutUint64(b, uint64(p.Time.UnixNano()))
+	binary.LittleEndian.PutUint32(b[8:], p.Address)
+	b[12] = byte(p.Channel)
+	b[13] = byte(p.Type)
+	binary.LittleEndian.PutUint64(b[14:], math.Float64bits(p.Value))
+	binary.LittleEndian.PutUint32(b[22:], crc32.ChecksumIEEE(b[:22]))
+}
+
+// decode reads the point record from b. Reports false if the record is corrupted.
+func decode(b []byte) (Point, bool) {
+	if binary.LittleEndian.Uint32(b[22:]) != crc32.ChecksumIEEE(b[:22]) {
+		return Point{}, false
+	}
+	return Point{
+		Address: binary.LittleEndian.Uint32(b[8:]),
+		Channel: uint(b[12]),
+		Type:    pulsar.ArchType(b[13]),
+		Time:    time.Unix(0, int64(binary.LittleEndian.Uint64(b))),
+		Value:   math.Float64frombits(binary.LittleEndian.Uint64(b[14:])),
+	}, true
+}
@@ -0,0 +1,344 @@
+// Package store is an embeddable file-based storage of device readings and archive records.
+//
+// Points are kept in a directory in a segment file per UTC day, so a collector may buffer weeks of data
+// locally and forward it later. Old segments are removed and downsampled according to retention policies
+// applied by Maintain. Store implements export.Writer, so it may be a target of export.WriteArchive
+// and backfill.Backfiller.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+)
+
+// Current is a Point type of current values.
+const Current pulsar.ArchType = 0
+
+// Point is a stored reading of a device channel.
+type Point struct {
+	// Device address.
+	Address uint32
+	// Channel number.
+	Channel uint
+	// Archive type of a record or Current for a current value.
+	Type pulsar.ArchType
+	// Reading time, i.e. the record period start for archive records.
+	Time time.Time
+	// Channel value.
+	Value float64
+}
+
+// Policy downsamples points older than Age to a point per Interval.
+// Segments are downsampled separately, so Interval must not exceed a day.
+type Policy struct {
+	Age      time.Duration
+	Interval time.Duration
+}
+
+// Options configures a Store.
+type Options struct {
+	// Points older than Retention are removed. Zero means points are kept forever.
+	Retention time.Duration
+	// Downsampling policies. Points of a series are replaced with the last point of every policy interval.
+	// As channel values are counters the last point keeps consumption of the interval.
+	Downsample []Policy
+}
+
+// Store is a file-based time-series storage of points.
+// Store is safe for concurrent use.
+type Store struct {
+	dir  string
+	opts Options
+	mu   sync.RWMutex
+}
+
+// Open opens a store in dir creating the directory if needed.
+func Open(dir string, opts Options) (*Store, error) {
+	if opts.Retention < 0 {
+		return nil, fmt.Errorf("invalid retention %v", opts.Retention)
+	}
+	for _, p := range opts.Downsample {
+		if p.Age < 0 || p.Interval <= 0 || p.Interval > 24*time.Hour {
+			return nil, fmt.Errorf("invalid downsampling policy %+v", p)
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, opts: opts}, nil
+}
+
+// Append stores points. Points out of retention period are dropped.
+// A point stored again with the same time replaces the previous one of the series.
+func (s *Store) Append(points ...Point) error {
+	days := make(map[time.Time][]Point)
+	var cutoff time.Time
+	if s.opts.Retention > 0 {
+		cutoff = time.Now().Add(-s.opts.Retention)
+	}
+	for _, p := range points {
+		if p.Channel > math.MaxUint8 {
+			return fmt.Errorf("invalid channel %d", p.Channel)
+		}
+		if p.Time.Before(cutoff) {
+			continue
+		}
+		day := segmentDay(p.Time)
+		days[day] = append(days[day], p)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for day, ps := range days {
+		if err := appendSegment(segmentPath(s.dir, day), ps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteLog stores archive records of the channel log. Missing records are skipped.
+func (s *Store) WriteLog(address uint32, l *pulsar.ChannelLog) error {
+	points := make([]Point, 0, len(l.Values))
+	for i, v := range l.Values {
+		if pulsar.IsNoData(v) {
+			continue
+		}
+		points = append(points, Point{Address: address, Channel: l.Id, Type: l.Type, Time: l.Time(i), Value: float64(v)})
+	}
+	return s.Append(points...)
+}
+
+// WriteValues stores current values read at t.
+func (s *Store) WriteValues(address uint32, t time.Time, values []pulsar.Channel) error {
+	points := make([]Point, 0, len(values))
+	for _, v := range values {
+		points = append(points, Point{Address: address, Channel: v.Id, Type: Current, Time: t, Value: v.Value})
+	}
+	return s.Append(points...)
+}
+
+// Flush does nothing as points are written by Append.
+func (s *Store) Flush() error {
+	return nil
+}
+
+// Aggregate is a function combining points of a downsampling interval.
+type Aggregate uint8
+
+const (
+	// Last is the last point of the interval.
+	Last Aggregate = iota
+	// Mean is an average value at the interval start.
+	Mean
+	// Min is a minimum value at the interval start.
+	Min
+	// Max is a maximum value at the interval start.
+	Max
+)
+
+// Query selects points of a series.
+type Query struct {
+	// Device address.
+	Address uint32
+	// Channel number.
+	Channel uint
+	// Archive type or Current.
+	Type pulsar.ArchType
+	// Time range, both bounds are included. Zero bounds aren't limited.
+	From, To time.Time
+	// If set points are downsampled to a point per Interval with Aggregate function.
+	// Intervals are aligned to Unix epoch.
+	Interval  time.Duration
+	Aggregate Aggregate
+}
+
+// Query returns points matching q sorted by time.
+func (s *Store) Query(q Query) ([]Point, error) {
+	var rv []Point
+	err := s.Scan(q, func(p Point) error {
+		rv = append(rv, p)
+		return nil
+	})
+	return rv, err
+}
+
+// Scan calls fn for points matching q in order of time. A segment file is read at once.
+// Scanning stops if fn returns an error, the error is returned by Scan.
+// fn must not modify the store.
+func (s *Store) Scan(q Query, fn func(Point) error) error {
+	if q.Interval < 0 || q.Aggregate > Max {
+		return errors.New("invalid downsampling")
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	segs, err := segments(s.dir)
+	if err != nil {
+		return err
+	}
+	filter := func(p Point) bool {
+		return p.Address == q.Address && p.Channel == q.Channel && p.Type == q.Type &&
+			(q.From.IsZero() || !p.Time.Before(q.From)) && (q.To.IsZero() || !p.Time.After(q.To))
+	}
+	emit := fn
+	var agg *aggregator
+	if q.Interval > 0 {
+		agg = &aggregator{interval: q.Interval, fn: q.Aggregate, emit: fn}
+		emit = agg.add
+	}
+	for _, seg := range segs {
+		if !q.From.IsZero() && seg.day.AddDate(0, 0, 1).Before(q.From) || !q.To.IsZero() && seg.day.After(q.To) {
+			continue
+		}
+		points, err := readSegment(seg.path, filter)
+		if err != nil {
+			return err
+		}
+		for _, p := range points {
+			if err := emit(p); err != nil {
+				return err
+			}
+		}
+	}
+	if agg != nil {
+		return agg.flush()
+	}
+	return nil
+}
+
+// aggregator downsamples points of a series sorted by time.
+type aggregator struct {
+	interval time.Duration
+	fn       Aggregate
+	emit     func(Point) error
+	// current interval point, its start and number of points.
+	cur   Point
+	start time.Time
+	n     int
+}
+
+func (a *aggregator) add(p Point) error {
+	start := align(p.Time, a.interval)
+	if a.n > 0 && !start.Equal(a.start) {
+		if err := a.flush(); err != nil {
+			return err
+		}
+	}
+	if a.n == 0 {
+		a.cur, a.start = p, start
+		a.n = 1
+		return nil
+	}
+	a.n++
+	switch a.fn {
+	case Last:
+		a.cur = p
+	case Mean:
+		a.cur.Value += p.Value
+	case Min:
+		a.cur.Value = math.Min(a.cur.Value, p.Value)
+	case Max:
+		a.cur.Value = math.Max(a.cur.Value, p.Value)
+	}
+	return nil
+}
+
+// flush emits the point of the current interval.
+func (a *aggregator) flush() error {
+	if a.n == 0 {
+		return nil
+	}
+	p := a.cur
+	if a.fn != Last {
+		p.Time = a.start
+	}
+	if a.fn == Mean {
+		p.Value /= float64(a.n)
+	}
+	a.n = 0
+	return a.emit(p)
+}
+
+// Maintain applies retention policies: removes segments older than Retention
+// and downsamples points older than policies Age. It is meant to be called periodically.
+func (s *Store) Maintain() error {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	segs, err := segments(s.dir)
+	if err != nil {
+		return err
+	}
+	policies := append([]Policy(nil), s.opts.Downsample...)
+	// the longest interval of applicable policies is used.
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Interval > policies[j].Interval })
+	for _, seg := range segs {
+		end := seg.day.AddDate(0, 0, 1)
+		if s.opts.Retention > 0 && !end.After(now.Add(-s.opts.Retention)) {
+			if err := os.Remove(seg.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		for _, p := range policies {
+			if end.After(now.Add(-p.Age)) {
+				continue
+			}
+			if err := downsample(seg.path, p.Interval); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// downsample replaces points of the segment file with the last point of every interval of a series.
+// Duplicate records are removed as well. The file isn't rewritten if there is nothing to remove.
+func downsample(path string, interval time.Duration) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	points, err := readSegment(path, nil)
+	if err != nil {
+		return err
+	}
+	type bucket struct {
+		address uint32
+		channel uint
+		typ     pulsar.ArchType
+		start   time.Time
+	}
+	// index of the last point by series interval, points are sorted by time.
+	last := make(map[bucket]int)
+	for i, p := range points {
+		last[bucket{p.Address, p.Channel, p.Type, align(p.Time, interval)}] = i
+	}
+	if int64(len(last)) == fi.Size()/recordLen {
+		return nil
+	}
+	rv := make([]Point, 0, len(last))
+	for i, p := range points {
+		if last[bucket{p.Address, p.Channel, p.Type, align(p.Time, interval)}] == i {
+			rv = append(rv, p)
+		}
+	}
+	return writeSegment(path, rv)
+}
+
+// align returns the start of the interval containing t, intervals are aligned to Unix epoch.
+func align(t time.Time, interval time.Duration) time.Time {
+	ns := t.UnixNano()
+	r := ns % int64(interval)
+	if r < 0 {
+		r += int64(interval)
+	}
+	return time.Unix(0, ns-r)
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+)
+
+func TestQuery(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2022, 7, 1, 22, 0, 0, 0, time.UTC)
+	l := &pulsar.ChannelLog{Id: 1, Type: pulsar.Hourly, Start: start, Values: []float32{1, pulsar.NoData, 3, 4}}
+	if err := s.WriteLog(0x00112233, l); err != nil {
+		t.Fatal(err)
+	}
+	// points are appended out of order.
+	if err := s.WriteValues(0x00112233, start.Add(90*time.Minute), []pulsar.Channel{{Id: 1, Value: 2.5}, {Id: 2, Value: 7}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteValues(0x00112233, start.Add(30*time.Minute), []pulsar.Channel{{Id: 1, Value: 1.5}}); err != nil {
+		t.Fatal(err)
+	}
+
+	points, err := s.Query(Query{Address: 0x00112233, Channel: 1, Type: pulsar.Hourly, From: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].Value != 3 || !points[0].Time.Equal(start.Add(2*time.Hour)) || points[1].Value != 4 {
+		t.Errorf("unexpected points %v", points)
+	}
+	points, err = s.Query(Query{Address: 0x00112233, Channel: 1, Type: Current})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].Value != 1.5 || points[1].Value != 2.5 {
+		t.Errorf("unexpected points %v", points)
+	}
+	points, err = s.Query(Query{Address: 0x00112233, Channel: 1, Type: pulsar.Hourly, Interval: 2 * time.Hour, Aggregate: Mean})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].Value != 1 || points[1].Value != 3.5 || !points[1].Time.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("unexpected mean points %v", points)
+	}
+
+	// intervals are aligned to Unix epoch, e.g. weeks start on Thursday.
+	week := 7 * 24 * time.Hour
+	at := time.Unix(0, 0).Add(2800 * week)
+	if err := s.Append(Point{Address: 1, Channel: 1, Type: Current, Time: at.Add(-time.Hour)}, Point{Address: 1, Channel: 1, Type: Current, Time: at.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	points, err = s.Query(Query{Address: 1, Channel: 1, Type: Current, Interval: week, Aggregate: Min})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || !points[1].Time.Equal(at) {
+		t.Errorf("unexpected weekly points %v", points)
+	}
+
+	// a partially written record is skipped and overwritten.
+	f, err := os.OpenFile(segmentPath(dir, start), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{1, 2, 3})
+	_ = f.Close()
+	if points, err = s.Query(Query{Address: 0x00112233, Channel: 2, Type: Current}); err != nil || len(points) != 1 {
+		t.Errorf("unexpected points %v %v", points, err)
+	}
+	if err := s.Append(Point{Address: 0x00112233, Channel: 2, Type: Current, Time: start, Value: 6}); err != nil {
+		t.Fatal(err)
+	}
+	if points, err = s.Query(Query{Address: 0x00112233, Channel: 2, Type: Current}); err != nil || len(points) != 2 || points[0].Value != 6 {
+		t.Errorf("unexpected points %v %v", points, err)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	s, err := Open(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	// a resumed backfill writes records already stored again.
+	for _, l := range []*pulsar.ChannelLog{
+		{Id: 1, Type: pulsar.Hourly, Start: start, Values: []float32{1, 2}},
+		{Id: 1, Type: pulsar.Hourly, Start: start.Add(time.Hour), Values: []float32{2.5, 3}},
+		{Id: 2, Type: pulsar.Hourly, Start: start, Values: []float32{7}},
+	} {
+		if err := s.WriteLog(1, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	points, err := s.Query(Query{Address: 1, Channel: 1, Type: pulsar.Hourly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 3 || points[1].Value != 2.5 || points[2].Value != 3 {
+		t.Errorf("unexpected points %v", points)
+	}
+
+	// duplicates are removed from segments by Maintain.
+	s.opts.Downsample = []Policy{{Interval: time.Hour}}
+	if err := s.Maintain(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(segmentPath(s.dir, start)); err != nil || fi.Size() != 4*recordLen {
+		t.Errorf("segment isn't compacted: %v", err)
+	}
+}
+
+func TestMaintain(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := segmentDay(time.Now())
+	var points []Point
+	for _, d := range []int{-20, -5, 0} {
+		for m := 0; m < 120; m += 10 {
+			points = append(points, Point{Address: 1, Channel: 1, Type: Current, Time: day.AddDate(0, 0, d).Add(time.Duration(m) * time.Minute), Value: float64(m)})
+		}
+	}
+	if err := s.Append(points...); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(dir, Options{Retention: 10 * 24 * time.Hour, Downsample: []Policy{
+		{Age: 24 * time.Hour, Interval: time.Hour},
+		{Age: 30 * 24 * time.Hour, Interval: 24 * time.Hour},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Maintain(); err != nil {
+		t.Fatal(err)
+	}
+	points, err = s.Query(Query{Address: 1, Channel: 1, Type: Current})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the oldest day is removed, the one 5 days ago is downsampled to the last points of hours.
+	if len(points) != 14 {
+		t.Fatalf("unexpected number of points %d", len(points))
+	}
+	if !points[0].Time.Equal(day.AddDate(0, 0, -5).Add(50*time.Minute)) || points[1].Value != 110 {
+		t.Errorf("unexpected downsampled points %v", points[:2])
+	}
+	if err := s.Append(Point{Address: 1, Channel: 1, Type: Current, Time: day.AddDate(0, 0, -11)}); err != nil {
+		t.Fatal(err)
+	}
+	if segs, _ := segments(dir); len(segs) != 2 {
+		t.Errorf("unexpected segments %v", segs)
+	}
+}
+
+func TestOpenPolicy(t *testing.T) {
+	if _, err := Open(t.TempDir(), Options{Downsample: []Policy{{Age: time.Hour, Interval: 48 * time.Hour}}}); err == nil {
+		t.Error("policy interval longer than a day is accepted")
+	}
+}
@@ -0,0 +1,230 @@
+package pulsar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultSyncThreshold is a clock drift corrected by SyncTime if SyncOptions.Threshold isn't set.
+const DefaultSyncThreshold = 5 * time.Second
+
+// DefaultHourGuard is a distance from an hour boundary used if SyncOptions.HourGuard isn't set.
+const DefaultHourGuard = time.Minute
+
+// ErrLargeDrift is returned by SyncTime if clock drift can't be corrected without crossing an hour boundary.
+var ErrLargeDrift = errors.New("clock drift is too large to be corrected within an hour")
+
+// SyncOptions configures clock synchronization.
+type SyncOptions struct {
+	// Minimum clock drift to correct. Defaults to DefaultSyncThreshold.
+	Threshold time.Duration
+	// Device clock is written only if both device and local clocks are at least HourGuard away from an hour boundary
+	// and the correction doesn't move device clock across it, otherwise hourly archive record may be lost or overwritten.
+	// Defaults to DefaultHourGuard.
+	HourGuard time.Duration
+	// Logger for clock drift before and after correction.
+	Logger *log.Logger
+}
+
+// SyncResult is an outcome of clock synchronization.
+type SyncResult struct {
+	// Device address.
+	Address uint32
+	// Device clock before the correction.
+	DeviceTime time.Time
+	// Device clock minus local clock before the correction, see HealthReport.ClockDrift.
+	Drift time.Duration
+	// Whether device clock was written.
+	Corrected bool
+	// Clock drift measured after the correction.
+	After time.Duration
+	// Time to retry at if the correction was postponed not to cross an hour boundary, zero otherwise.
+	RetryAt time.Time
+}
+
+// SyncTime corrects device clock if it drifts from the local one more than the threshold.
+// Round trip time of the requests is taken into account, the clock is written at the start of a second
+// as device clock resolution is a second.
+// If the correction is close to an hour boundary it is postponed till SyncResult.RetryAt.
+func (c *Client) SyncTime(opts SyncOptions) (*SyncResult, error) {
+	return c.SyncTimeContext(context.Background(), opts)
+}
+
+// SyncTimeContext is like SyncTime but aborts the request when ctx is done.
+func (c *Client) SyncTimeContext(ctx context.Context, opts SyncOptions) (*SyncResult, error) {
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultSyncThreshold
+	}
+	if opts.HourGuard <= 0 {
+		opts.HourGuard = DefaultHourGuard
+	}
+	dev, local, rtt, err := c.readClock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := &SyncResult{Address: c.address, DeviceTime: dev, Drift: dev.Sub(local)}
+	c.logf(opts.Logger, "clock %v, drift %v", dev.Format(time.RFC3339), r.Drift)
+	if abs(r.Drift) <= opts.Threshold {
+		return r, nil
+	}
+	if abs(r.Drift)+2*opts.HourGuard >= time.Hour {
+		return r, ErrLargeDrift
+	}
+	if wait := hourCrossing(wallClock(dev), wallClock(local), opts.HourGuard); wait > 0 {
+		r.RetryAt = time.Now().Add(wait)
+		c.logf(opts.Logger, "correction crosses an hour boundary, postponed till %v", r.RetryAt.Format(time.RFC3339))
+		return r, nil
+	}
+
+	// the request reaches device in a half of round trip time.
+	target := time.Now().Add(rtt / 2).Truncate(time.Second).Add(time.Second)
+	if err := sleep(ctx, time.Until(target.Add(-rtt/2))); err != nil {
+		return r, err
+	}
+	if err := c.SetSysTimeContext(ctx, target); err != nil {
+		return r, err
+	}
+	r.Corrected = true
+	if dev, local, _, err = c.readClock(ctx); err != nil {
+		return r, err
+	}
+	r.After = dev.Sub(local)
+	c.logf(opts.Logger, "clock corrected, drift %v", r.After)
+	return r, nil
+}
+
+// ClockDrift reads device clock and returns it along with its drift, see HealthReport.ClockDrift.
+func (c *Client) ClockDrift() (time.Time, time.Duration, error) {
+	return c.ClockDriftContext(context.Background())
+}
+
+// ClockDriftContext is like ClockDrift but aborts the request when ctx is done.
+func (c *Client) ClockDriftContext(ctx context.Context) (time.Time, time.Duration, error) {
+	dev, local, _, err := c.readClock(ctx)
+	if err != nil {
+		return dev, 0, err
+	}
+	return dev, dev.Sub(local), nil
+}
+
+// readClock reads device clock and returns it along with the local clock at the moment of reading
+// and request round trip time.
+// Local clock is in device clock location or is a wall clock in UTC if the client has no location.
+func (c *Client) readClock(ctx context.Context) (time.Time, time.Time, time.Duration, error) {
+	// daylight saving setting may be read on the first time conversion, it mustn't add to the round trip.
+	if _, err := c.zone(ctx); err != nil {
+		return time.Time{}, time.Time{}, 0, err
+	}
+	sent := time.Now()
+	t, err := c.SysTimeContext(ctx)
+	if err != nil {
+		return t, t, 0, err
+	}
+	rtt := time.Since(sent)
+	local := sent.Add(rtt / 2)
+	if c.loc == nil {
+		return t, wallClock(local), rtt, nil
+	}
+	return t, local.In(t.Location()), rtt, nil
+}
+
+// hourCrossing returns time to wait till device clock can be moved from dev to local wall clock
+// without crossing an hour boundary and staying guard away from it. Zero means no wait is required.
+func hourCrossing(dev, local time.Time, guard time.Duration) time.Duration {
+	lo, hi := dev, local
+	if hi.Before(lo) {
+		lo, hi = hi, lo
+	}
+	boundary := hi.Add(guard).Truncate(time.Hour)
+	if !boundary.After(lo.Add(-guard)) {
+		return 0
+	}
+	return boundary.Sub(lo) + guard
+}
+
+// logf logs a message of the device if logger is set.
+func (c *Client) logf(l *log.Logger, format string, v ...interface{}) {
+	if l != nil {
+		l.Printf("%08x: %s", c.address, fmt.Sprintf(format, v...))
+	}
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// SyncError reports devices failed to be synchronized by their address.
+type SyncError map[uint32]error
+
+func (e SyncError) Error() string {
+	addrs := make([]uint32, 0, len(e))
+	for a := range e {
+		addrs = append(addrs, a)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	var b strings.Builder
+	for i, a := range addrs {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		fmt.Fprintf(&b, "%08x: %v", a, e[a])
+	}
+	return b.String()
+}
+
+// SyncClocks runs SyncTime for every client of the bus one by one.
+// Results of devices synchronized are returned along with SyncError if some of them failed.
+func (b *Bus) SyncClocks(opts SyncOptions) ([]*SyncResult, error) {
+	return b.SyncClocksContext(context.Background(), opts)
+}
+
+// SyncClocksContext is like SyncClocks but aborts the request when ctx is done.
+func (b *Bus) SyncClocksContext(ctx context.Context, opts SyncOptions) ([]*SyncResult, error) {
+	var rv []*SyncResult
+	errs := make(SyncError)
+	for _, c := range b.Clients() {
+		r, err := c.SyncTimeContext(ctx, opts)
+		if err := ctx.Err(); err != nil {
+			return rv, err
+		}
+		if r != nil {
+			rv = append(rv, r)
+		}
+		if err != nil {
+			errs[c.address] = err
+			c.logf(opts.Logger, "clock synchronization failed: %v", err)
+		}
+	}
+	if len(errs) > 0 {
+		return rv, errs
+	}
+	return rv, nil
+}
+
+// RunClockSync runs SyncClocks every interval until ctx is done.
+// Postponed corrections are retried at their SyncResult.RetryAt. Returns ctx error.
+func (b *Bus) RunClockSync(ctx context.Context, interval time.Duration, opts SyncOptions) error {
+	if interval <= 0 {
+		return fmt.Errorf("synchronization interval must be positive")
+	}
+	for {
+		rs, _ := b.SyncClocksContext(ctx, opts)
+		next := time.Now().Add(interval)
+		for _, r := range rs {
+			if !r.RetryAt.IsZero() && r.RetryAt.Before(next) {
+				next = r.RetryAt
+			}
+		}
+		if err := sleep(ctx, time.Until(next)); err != nil {
+			return err
+		}
+	}
+}
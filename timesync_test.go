@@ -0,0 +1,102 @@
+package pulsar_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+	"github.com/srgsf/tvh-pulsar/simulator"
+)
+
+func TestSyncTime(t *testing.T) {
+	d, cl := startDevice(t, 1)
+	d.Drift(30 * time.Second)
+	var buf bytes.Buffer
+	opts := pulsar.SyncOptions{Logger: log.New(&buf, "", 0)}
+
+	r, err := cl.SyncTime(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.RetryAt.IsZero() {
+		t.Skip("too close to an hour boundary")
+	}
+	if !r.Corrected || r.Drift < 29*time.Second {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if r.After > time.Second || r.After < -time.Second {
+		t.Errorf("unexpected drift after correction %v", r.After)
+	}
+	if drift := time.Until(d.Now()); drift > time.Second || drift < -time.Second {
+		t.Errorf("device clock is not corrected, drift %v", drift)
+	}
+	if out := buf.String(); !strings.Contains(out, "00000001: clock corrected") {
+		t.Errorf("unexpected log:\n%s", out)
+	}
+
+	if r, err = cl.SyncTime(opts); err != nil || r.Corrected {
+		t.Errorf("clock within threshold is corrected: %+v, %v", r, err)
+	}
+
+	d.Drift(-2 * time.Hour)
+	if r, err = cl.SyncTime(opts); !errors.Is(err, pulsar.ErrLargeDrift) || r.Corrected {
+		t.Errorf("large drift is corrected: %+v, %v", r, err)
+	}
+}
+
+func TestClockDrift(t *testing.T) {
+	d, cl := startDevice(t, 1)
+	d.Drift(-30 * time.Second)
+	dev, drift, err := cl.ClockDrift()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drift > -29*time.Second || drift < -31*time.Second {
+		t.Errorf("unexpected drift %v", drift)
+	}
+	if dev.IsZero() {
+		t.Error("device time isn't returned")
+	}
+}
+
+func TestSyncClocks(t *testing.T) {
+	s, b := startBus(t, 1, 2)
+	d1, d2 := s.Device(1), s.Device(2)
+	for _, addr := range []string{"00000001", "00000002", "00000003"} {
+		if _, err := b.Client(addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d2.Drift(-20 * time.Second)
+	if err := b.RunClockSync(context.Background(), 0, pulsar.SyncOptions{}); err == nil {
+		t.Error("zero interval is accepted")
+	}
+
+	rs, err := b.SyncClocks(pulsar.SyncOptions{})
+	var se pulsar.SyncError
+	if !errors.As(err, &se) || len(se) != 1 || se[3] == nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if len(rs) != 2 || rs[0].Address != 1 || rs[1].Address != 2 {
+		t.Fatalf("unexpected results %+v", rs)
+	}
+	if rs[0].Corrected {
+		t.Error("clock within threshold is corrected")
+	}
+	if !rs[1].RetryAt.IsZero() {
+		t.Skip("too close to an hour boundary")
+	}
+	if !rs[1].Corrected {
+		t.Error("clock isn't corrected")
+	}
+	for i, d := range []*simulator.Device{d1, d2} {
+		if drift := time.Until(d.Now()); drift > 2*time.Second || drift < -2*time.Second {
+			t.Errorf("device %d: unexpected drift %v", i+1, drift)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package pulsar
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// daylight saving states cached by client.
+const (
+	dstUnknown int32 = iota
+	dstOff
+	dstOn
+)
+
+// WithLocation sets the location of device clock.
+// Device times are decoded in loc and request times are converted to it.
+// If device daylight saving is disabled the clock stays at loc standard time, see DeviceLocation.
+// The setting is read from device on the first time conversion and tracked by SetDayLightSaving.
+// Without location device times are decoded as UTC and request times are sent as wall clock of their location.
+func WithLocation(loc *time.Location) ClientOption {
+	return func(c *Client) {
+		c.loc = loc
+	}
+}
+
+// Location returns the location of device clock or nil if it isn't configured.
+func (c *Client) Location() *time.Location {
+	return c.loc
+}
+
+// DeviceLocation returns location of a device clock in loc.
+// If dst is false the location is loc standard time without daylight saving transitions.
+func DeviceLocation(loc *time.Location, dst bool) *time.Location {
+	if dst {
+		return loc
+	}
+	// standard offset is the smallest one during a year.
+	year := time.Now().Year()
+	name, offset := time.Date(year, time.January, 1, 0, 0, 0, 0, loc).Zone()
+	n, o := time.Date(year, time.July, 1, 0, 0, 0, 0, loc).Zone()
+	if o == offset {
+		return loc
+	}
+	if o < offset {
+		name, offset = n, o
+	}
+	return time.FixedZone(name, offset)
+}
+
+// zone returns device clock location or nil if client has no location.
+func (c *Client) zone(ctx context.Context) (*time.Location, error) {
+	if c.loc == nil {
+		return nil, nil
+	}
+	switch atomic.LoadInt32(&c.dst) {
+	case dstOn:
+		return c.loc, nil
+	case dstOff:
+		return DeviceLocation(c.loc, false), nil
+	}
+	dst, err := c.DayLightSavingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.setDST(dst)
+	return DeviceLocation(c.loc, dst), nil
+}
+
+// setDST caches device daylight saving setting.
+func (c *Client) setDST(dst bool) {
+	v := dstOff
+	if dst {
+		v = dstOn
+	}
+	atomic.StoreInt32(&c.dst, v)
+}
+
+// toDevice converts t to device clock location.
+func (c *Client) toDevice(ctx context.Context, t time.Time) (time.Time, error) {
+	loc, err := c.zone(ctx)
+	if err != nil || loc == nil {
+		return t, err
+	}
+	return t.In(loc), nil
+}
+
+// fromDevice stamps wall clock time decoded from device with device clock location.
+func (c *Client) fromDevice(ctx context.Context, t time.Time) (time.Time, error) {
+	loc, err := c.zone(ctx)
+	if err != nil || loc == nil {
+		return t, err
+	}
+	return inLocation(t, loc), nil
+}
+
+// inLocation returns time with wall clock of t in loc.
+// Ambiguous wall clock at the end of daylight saving time resolves to the earlier instant,
+// nonexistent one at the beginning is normalized by time.Date, i.e. moved forward.
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	rv := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+	for _, d := range []time.Duration{-time.Hour, -30 * time.Minute} {
+		if e := rv.Add(d); wallClock(e).Equal(wallClock(rv)) {
+			return e
+		}
+	}
+	return rv
+}
+
+// inLocation converts the log of records kept by device wall clock to loc.
+// Hourly records are mapped to consecutive hours: nonexistent hour at the beginning of daylight saving time
+// is dropped, the earlier of repeated hours at its end is NoData as device keeps a single record per wall clock hour.
+func (l *ChannelLog) inLocation(loc *time.Location) {
+	start := l.Start
+	l.Start = inLocation(start, loc)
+	for i, g := range l.Gaps {
+		l.Gaps[i] = Gap{From: inLocation(g.From, loc), To: inLocation(g.To, loc)}
+	}
+	if l.Type != Hourly || len(l.Values) == 0 {
+		return
+	}
+	last := Hourly.Advance(start, len(l.Values)-1)
+	values := make([]float32, 0, len(l.Values))
+	for t := l.Start; ; t = t.Add(time.Hour) {
+		w := wallClock(t.In(loc))
+		if w.After(last) {
+			break
+		}
+		v := NoData
+		if !wallClock(t.Add(time.Hour).In(loc)).Equal(w) {
+			v = l.Values[periods(Hourly, start, w)]
+		}
+		values = append(values, v)
+	}
+	l.Values = values
+}
+
+// wallClock returns wall clock reading of t in the form decoded from device, i.e. in UTC.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
@@ -0,0 +1,104 @@
+package pulsar_test
+
+import (
+	"testing"
+	"time"
+
+	pulsar "github.com/srgsf/tvh-pulsar"
+	"github.com/srgsf/tvh-pulsar/simulator"
+)
+
+func TestDeviceLocation(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	summer := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	if _, offset := summer.In(pulsar.DeviceLocation(loc, true)).Zone(); offset != -4*3600 {
+		t.Errorf("unexpected daylight saving offset %d", offset)
+	}
+	if _, offset := summer.In(pulsar.DeviceLocation(loc, false)).Zone(); offset != -5*3600 {
+		t.Errorf("unexpected standard offset %d", offset)
+	}
+	if l := pulsar.DeviceLocation(time.UTC, false); l != time.UTC {
+		t.Errorf("location without daylight saving is changed to %v", l)
+	}
+}
+
+func TestSysTimeLocation(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	d, cl := startDevice(t, 1, pulsar.WithLocation(loc))
+	d.SetLocation(loc)
+
+	for _, dst := range []bool{true, false} {
+		if err := cl.SetDayLightSaving(dst); err != nil {
+			t.Fatal(err)
+		}
+		tm, err := cl.SysTime()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if drift := time.Since(tm); drift > 2*time.Second || drift < -2*time.Second {
+			t.Errorf("dst %v: unexpected device time %v", dst, tm)
+		}
+		_, expected := time.Now().In(pulsar.DeviceLocation(loc, dst)).Zone()
+		if _, offset := tm.Zone(); offset != expected {
+			t.Errorf("dst %v: expected offset %d got %d", dst, expected, offset)
+		}
+	}
+
+	target := time.Now().Add(-3 * time.Hour).UTC()
+	if err := cl.SetSysTime(target); err != nil {
+		t.Fatal(err)
+	}
+	if drift := d.Now().Sub(target); drift > 2*time.Second || drift < -2*time.Second {
+		t.Errorf("expected device time %v got %v", target, d.Now())
+	}
+}
+
+func TestArchiveLocation(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	d, cl := startDevice(t, 1, pulsar.WithLocation(loc))
+	d.SetLocation(loc)
+	d.SetDayLightSaving(true)
+	start := time.Date(2022, 3, 27, 0, 0, 0, 0, loc)
+	d.SetArchive(pulsar.Hourly, 1, start, []float32{1, 2, 3, 4})
+
+	from, to := start.UTC(), start.Add(2*time.Hour).UTC()
+	l, err := cl.HourlyLog(1, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Start.Equal(start) || l.Start.Location() != loc {
+		t.Errorf("expected start %v got %v", start, l.Start)
+	}
+	l, err = cl.Archive(pulsar.Hourly, 1, from, to, pulsar.ArchiveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []float32{1, 2, 3}
+	if len(l.Values) != len(expected) {
+		t.Fatalf("expected values %v got %v", expected, l.Values)
+	}
+	for i, r := range l.Records() {
+		if r.Value != expected[i] || !r.Time.Equal(start.Add(time.Duration(i)*time.Hour)) {
+			t.Errorf("record %d: unexpected %v %v", i, r.Time, r.Value)
+		}
+	}
+
+	// only fetched records are returned on failure.
+	d.InjectFaults(simulator.ReadArchive, simulator.Fault{Kind: simulator.ErrorReply, Code: pulsar.MissingParam, Skip: 1, Count: 1})
+	l, err = cl.Archive(pulsar.Hourly, 1, from, start.Add(3*time.Hour), pulsar.ArchiveOptions{ChunkSize: 2})
+	if err == nil {
+		t.Fatal("error isn't returned")
+	}
+	if len(l.Values) != 2 || l.Values[1] != 2 {
+		t.Errorf("unexpected values %v", l.Values)
+	}
+}